@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	html2puggo "github.com/chrisbward/html2pug-go/pkg/html2pug-go"
+	html2puggo_entities "github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	outputPath := flag.String("o", "", "write the Pug to this file instead of stdout")
+	nSpaces := flag.Int("nspaces", 2, "number of spaces to indent with")
+	useTabs := flag.Bool("tabs", false, "indent with tabs")
+	keepHead := flag.Bool("keephead", false, "keep the head element")
+	bodyless := flag.Bool("bodyless", false, "omit the html, head and body wrappers")
+	xhtml := flag.Bool("xhtml", false, "parse the input as XHTML")
+	selector := flag.String("select", "", "convert only the elements matching this CSS selector list")
+	block := flag.String("block", "", "wrap the -select matches in a block with this name")
+	rulesPath := flag.String("rules", "", "apply the transform rules of this YAML file before converting")
+	trace := flag.String("trace", "", "write the conversion decisions per node to stderr as \"log\" or \"json\"")
+	sourceMapPath := flag.String("sourcemap", "", "write a Source Map v3 file mapping the Pug lines to the HTML")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: html2pug [flags] [file.html]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *block != "" && *selector == "" {
+		fmt.Fprintln(os.Stderr, "-block requires -select")
+		os.Exit(2)
+	}
+
+	inputName := "<stdin>"
+	var input io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		inputName = flag.Arg(0)
+		file, err := os.Open(inputName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	content, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	options := &html2puggo_entities.Html2JadeConvertorOptions{
+		NSpaces:  *nSpaces,
+		UseTabs:  *useTabs,
+		KeepHead: *keepHead,
+		Bodyless: *bodyless,
+
+		Select:      *selector,
+		SelectBlock: *block,
+	}
+	if *xhtml {
+		options.InputType = html2puggo_entities.XHTMLProgramInputType
+	}
+	if *rulesPath != "" {
+		rules, err := html2puggo.LoadTransformRules(*rulesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options.Rules = rules
+	}
+	switch *trace {
+	case "":
+	case "log":
+		logger := logrus.New()
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logrus.DebugLevel)
+		tracer := html2puggo.NewLogTracer(logger)
+		options.Tracer = &tracer
+	case "json":
+		tracer := html2puggo.NewJSONTracer(os.Stderr)
+		options.Tracer = &tracer
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -trace format %q\n", *trace)
+		os.Exit(2)
+	}
+
+	exitCode := 0
+	write := func(err error, jadeOutput string, diagnostics []html2puggo_entities.Diagnostic) {
+		// Diagnostics go to stderr so the Pug can still be piped
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", inputName, diagnostic)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", inputName, err)
+			exitCode = 1
+			return
+		}
+
+		if *outputPath == "" {
+			fmt.Print(jadeOutput)
+			return
+		}
+		if err := os.WriteFile(*outputPath, []byte(jadeOutput), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+		}
+	}
+
+	pugConvertor := html2puggo.NewHtml2PugConvertor(options)
+	if *sourceMapPath == "" {
+		pugConvertor.ConvertHTMLWithDiagnostics(string(content), write)
+		os.Exit(exitCode)
+	}
+
+	sourceMapOptions := html2puggo_entities.SourceMapOptions{
+		File:   filepath.Base(*outputPath),
+		Source: filepath.Base(inputName),
+	}
+	if *outputPath == "" {
+		sourceMapOptions.File = ""
+	}
+	pugConvertor.ConvertHTMLWithSourceMap(string(content), sourceMapOptions, func(err error, jadeOutput string, sourceMap *html2puggo_entities.SourceMap, diagnostics []html2puggo_entities.Diagnostic) {
+		write(err, jadeOutput, diagnostics)
+		if exitCode != 0 {
+			return
+		}
+		data, err := json.Marshal(sourceMap)
+		if err == nil {
+			err = os.WriteFile(*sourceMapPath, data, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+		}
+	})
+
+	os.Exit(exitCode)
+}
@@ -1,13 +1,29 @@
 package pkg
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	html "golang.org/x/net/html"
 )
 
 type Html2PugConvertor struct {
 	Options *entities.Html2JadeConvertorOptions
+
+	// ruleTransforms are compiled from Options.Rules once, an invalid rule
+	// failing every conversion with rulesErr
+	ruleTransforms []entities.Transform
+	rulesErr       error
+	// selectErr fails every conversion when Options.Select is invalid
+	selectErr error
+}
+
+// request describes what a conversion reports besides the Pug output.
+type request struct {
+	diagnostics bool
+	sourceMap   bool
 }
 
 func NewHtml2PugConvertor(options *entities.Html2JadeConvertorOptions) (html2jadeConvertor entities.IHtml2JadeConvertor) {
@@ -17,6 +33,11 @@ func NewHtml2PugConvertor(options *entities.Html2JadeConvertorOptions) (html2jad
 	}
 	applyOptions(options)
 
+	if options.Parser == nil && options.InputType == entities.XHTMLProgramInputType {
+		parser := NewXMLParser(options)
+		options.Parser = &parser
+	}
+
 	if options.Parser == nil {
 		parser := NewParser(options)
 		options.Parser = &parser
@@ -31,34 +52,188 @@ func NewHtml2PugConvertor(options *entities.Html2JadeConvertorOptions) (html2jad
 		writer := NewWriter(options)
 		options.Writer = &writer
 	}
+	if options.ExtractAssets != nil && options.Assets == nil {
+		assets := NewAssetExtractor(options)
+		options.Assets = &assets
+	}
 	if options.Converter == nil {
 		convertor := NewConvertor(options)
 		options.Converter = &convertor
 	}
 
-	html2jadeConvertor = &Html2PugConvertor{
+	h2jc := &Html2PugConvertor{
 		Options: options,
 	}
+	h2jc.ruleTransforms, h2jc.rulesErr = RuleTransforms(options.Rules)
+	if options.Select != "" {
+		var selector *util.Selector
+		selector, h2jc.selectErr = util.CompileSelector(options.Select)
+		// Selection happens in the Convertor, a custom Converter would
+		// silently convert the whole document
+		if convertor, ok := (*options.Converter).(*Convertor); ok {
+			convertor.Selector = selector
+		} else if h2jc.selectErr == nil {
+			h2jc.selectErr = errors.New("Select requires the built-in Converter")
+		}
+	}
+	html2jadeConvertor = h2jc
 
 	return
 }
 
 // ConvertHTML
 func (h2jc *Html2PugConvertor) ConvertHTML(html string, callback entities.Html2JadeConvertorConvertDocumentCallback) {
+	h2jc.convert(html, request{}, finalOutput(func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+		callback(err, jadeOutput)
+	}))
+}
+
+// ConvertHTMLWithDiagnostics converts like ConvertHTML, also passing the
+// diagnostics of lossy or risky conversions to the callback.
+func (h2jc *Html2PugConvertor) ConvertHTMLWithDiagnostics(html string, callback entities.Html2JadeConvertorConvertDocumentWithDiagnosticsCallback) {
+	h2jc.convert(html, request{diagnostics: true}, finalOutput(callback))
+}
+
+// ConvertNode converts a document node like ConvertHTML, or any other node
+// as a fragment. The node's tree is left unchanged.
+func (h2jc *Html2PugConvertor) ConvertNode(node *html.Node, callback entities.Html2JadeConvertorConvertDocumentWithDiagnosticsCallback) {
+	h2jc.ConvertNodes([]*html.Node{node}, callback)
+}
+
+// ConvertNodes converts a list of sibling nodes, e.g. from
+// html.ParseFragment, as Pug roots. The nodes' trees are left unchanged.
+func (h2jc *Html2PugConvertor) ConvertNodes(nodes []*html.Node, callback entities.Html2JadeConvertorConvertDocumentWithDiagnosticsCallback) {
+	var root *html.Node
+	if len(nodes) == 1 && nodes[0] != nil && nodes[0].Type == html.DocumentNode {
+		root = util.CloneNode(nodes[0], nil)
+	} else {
+		root = &html.Node{Type: html.DocumentNode}
+		for _, node := range nodes {
+			if node != nil {
+				root.AppendChild(util.CloneNode(node, nil))
+			}
+		}
+	}
+
+	h2jc.convertDocument(&entities.Document{Root: root}, request{diagnostics: true}, finalOutput(callback))
+}
+
+// ConvertDocument converts a parsed document, keeping its source positions.
+// The document is left unchanged.
+func (h2jc *Html2PugConvertor) ConvertDocument(document *entities.Document, callback entities.Html2JadeConvertorConvertDocumentWithDiagnosticsCallback) {
+	if document == nil || document.Root == nil {
+		callback(errors.New("document has no root node"), "", nil)
+		return
+	}
+
+	// The conversion works on a copy, as transforms and the convertor may
+	// change the tree
+	clones := map[*html.Node]*html.Node{}
+	owned := &entities.Document{
+		DocumentElement: document.DocumentElement,
+		Root:            util.CloneNode(document.Root, clones),
+	}
+	if positions := document.Locate(); positions != nil {
+		owned.Positions = make(map[*html.Node]entities.Position, len(positions))
+		for node, position := range positions {
+			if clone, ok := clones[node]; ok {
+				owned.Positions[clone] = position
+			}
+		}
+	}
+
+	h2jc.convertDocument(owned, request{diagnostics: true}, finalOutput(callback))
+}
+
+// finalOutput adapts callback to receive the finished output.
+func finalOutput(callback entities.Html2JadeConvertorConvertDocumentWithDiagnosticsCallback) func(err error, output entities.IStringWriter, diagnostics []entities.Diagnostic) {
+	return func(err error, output entities.IStringWriter, diagnostics []entities.Diagnostic) {
+		if err != nil {
+			callback(err, "", diagnostics)
+			return
+		}
+		callback(nil, output.Final(), diagnostics)
+	}
+}
+
+// ConvertHTMLWithSourceMap converts like ConvertHTMLWithDiagnostics, also
+// passing a source map from the Pug lines back to the HTML.
+func (h2jc *Html2PugConvertor) ConvertHTMLWithSourceMap(html string, options entities.SourceMapOptions, callback entities.Html2JadeConvertorConvertDocumentWithSourceMapCallback) {
+	h2jc.convert(html, request{diagnostics: true, sourceMap: true}, func(err error, output entities.IStringWriter, diagnostics []entities.Diagnostic) {
+		if err != nil {
+			callback(err, "", nil, diagnostics)
+			return
+		}
+
+		// Final adjusts the mappings to the finished output
+		jadeOutput := output.Final()
+		var mappings []entities.SourceMapping
+		if sourceMapper, ok := output.(entities.ISourceMapper); ok {
+			mappings = sourceMapper.GetMappings()
+		}
+		callback(nil, jadeOutput, NewSourceMap(mappings, options, html), diagnostics)
+	})
+}
+
+func (h2jc *Html2PugConvertor) convert(html string, req request, callback func(err error, output entities.IStringWriter, diagnostics []entities.Diagnostic)) {
 
 	htmlReader := strings.NewReader(html)
 	(*h2jc.Options.Parser).Parse(htmlReader, func(err []error, window entities.Window) {
 		if len(err) > 0 {
+			diagnostics := make([]entities.Diagnostic, 0, len(err))
+			for _, e := range err {
+				diagnostics = append(diagnostics, entities.Diagnostic{
+					Severity: entities.ErrorDiagnosticSeverity,
+					Code:     entities.ParseDiagnosticCode,
+					Message:  e.Error(),
+				})
+			}
+			callback(errors.Join(err...), nil, diagnostics)
 			return
 		}
-		stringOutput := NewStringOutput(h2jc.Options).(entities.IStringWriter)
+		h2jc.convertDocument(window.Document, req, callback)
+	})
 
-		(*h2jc.Options.Converter).Document(window.Document, &stringOutput)
+}
 
-		callback(nil, stringOutput.Final())
+// convertDocument transforms and converts a document the converter owns.
+func (h2jc *Html2PugConvertor) convertDocument(document *entities.Document, req request, callback func(err error, output entities.IStringWriter, diagnostics []entities.Diagnostic)) {
+	// Every asset would fail to extract, so fail the conversion instead
+	if h2jc.Options.Assets != nil && h2jc.Options.OutDirectoryPath == "" {
+		if _, ok := (*h2jc.Options.Assets).(*AssetExtractor); ok {
+			callback(errNoAssetDirectory, nil, nil)
+			return
+		}
+	}
+	if h2jc.selectErr != nil {
+		callback(h2jc.selectErr, nil, nil)
+		return
+	}
+	if h2jc.rulesErr != nil {
+		callback(h2jc.rulesErr, nil, nil)
+		return
+	}
+	// Positions are matched against the parsed tree, before any transform
+	if req.diagnostics || req.sourceMap || h2jc.Options.Tracer != nil {
+		document.Positions = document.Locate()
+	}
+	document.Transform(h2jc.ruleTransforms...)
+	document.Transform(h2jc.Options.Transforms...)
 
-	})
+	stringOutput := NewStringOutput(h2jc.Options).(entities.IStringWriter)
+	if req.sourceMap {
+		stringOutput = NewSourceMapOutput(h2jc.Options)
+	}
+
+	var diagnostics []entities.Diagnostic
+	diagnosticConvertor, ok := (*h2jc.Options.Converter).(entities.IDiagnosticConvertor)
+	if req.diagnostics && ok {
+		diagnostics = diagnosticConvertor.DocumentWithDiagnostics(document, &stringOutput)
+	} else {
+		(*h2jc.Options.Converter).Document(document, &stringOutput)
+	}
 
+	callback(nil, stringOutput, diagnostics)
 }
 
 func applyOptions(options *entities.Html2JadeConvertorOptions) {
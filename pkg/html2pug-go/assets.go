@@ -0,0 +1,288 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	html "golang.org/x/net/html"
+)
+
+// dataURIAttrs are the attributes whose whole value may be a data: URI.
+var dataURIAttrs = map[string]bool{
+	"src":    true,
+	"href":   true,
+	"poster": true,
+	"data":   true,
+}
+
+type AssetExtractor struct {
+	Options *entities.Html2JadeConvertorOptions
+	Written map[string]bool
+
+	mu sync.Mutex
+}
+
+// NewAssetExtractor
+func NewAssetExtractor(options *entities.Html2JadeConvertorOptions) (assetExtractor entities.IAssetExtractor) {
+	assetExtractor = &AssetExtractor{
+		Options: options,
+		Written: map[string]bool{},
+	}
+	return
+}
+
+func (ae *AssetExtractor) directory() string {
+	if ae.Options.ExtractAssets != nil && ae.Options.ExtractAssets.Directory != "" {
+		return ae.Options.ExtractAssets.Directory
+	}
+	return "assets"
+}
+
+// errNoAssetDirectory is returned when assets are extracted without an
+// output directory.
+var errNoAssetDirectory = errors.New("asset extraction requires OutDirectoryPath")
+
+// Extract implements entities.IAssetExtractor.
+// The file name is derived from the content hash, so the same asset found in
+// several documents is only written once.
+func (ae *AssetExtractor) Extract(content []byte, ext string) (fileName string, err error) {
+	if ae.Options.OutDirectoryPath == "" {
+		return "", errNoAssetDirectory
+	}
+
+	sum := sha256.Sum256(content)
+	fileName = hex.EncodeToString(sum[:])[:16] + ext
+
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	if ae.Written[fileName] {
+		return
+	}
+
+	dir := filepath.Join(ae.Options.OutDirectoryPath, ae.directory())
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	filePath := filepath.Join(dir, fileName)
+	if _, statErr := os.Stat(filePath); statErr != nil {
+		if err = os.WriteFile(filePath, content, 0o644); err != nil {
+			return "", err
+		}
+	}
+
+	ae.Written[fileName] = true
+	return
+}
+
+// Include implements entities.IAssetExtractor.
+func (ae *AssetExtractor) Include(fileName string) string {
+	return path.Join(ae.directory(), fileName)
+}
+
+// URL implements entities.IAssetExtractor.
+func (ae *AssetExtractor) URL(fileName string) string {
+	prefix := ae.directory() + "/"
+	if ae.Options.ExtractAssets != nil && ae.Options.ExtractAssets.URLPrefix != "" {
+		prefix = ae.Options.ExtractAssets.URLPrefix
+	}
+	return prefix + fileName
+}
+
+// DecodeDataURI returns the payload and file extension of a data: URI.
+func DecodeDataURI(uri string) (content []byte, ext string, ok bool) {
+	if !strings.HasPrefix(uri, "data:") {
+		return nil, "", false
+	}
+
+	header, data, found := strings.Cut(uri[len("data:"):], ",")
+	if !found {
+		return nil, "", false
+	}
+
+	params := strings.Split(header, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	isBase64 := params[len(params)-1] == "base64"
+
+	var err error
+	if isBase64 {
+		content, err = base64.StdEncoding.DecodeString(data)
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(data)
+		content = []byte(unescaped)
+	}
+	if err != nil {
+		return nil, "", false
+	}
+
+	ext = ".bin"
+	switch mediaType {
+	case "image/svg+xml":
+		ext = ".svg"
+	case "image/jpeg":
+		ext = ".jpg"
+	case "", "text/plain":
+		ext = ".txt"
+	default:
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	return content, ext, true
+}
+
+// sourceLanguage reports whether a script or style is written in a language
+// compiled by a Pug filter or named by lang, e.g. lang="scss", rather than
+// plain JavaScript or CSS. Such nodes are left to the filters.
+func (c *Convertor) sourceLanguage(node *html.Node) bool {
+	if _, _, ok := c.Filter(node); ok {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(util.GetAttr(node, "lang"))) {
+	case "", "css", "js", "javascript":
+		return false
+	}
+	return true
+}
+
+// Assets extracts inline scripts, styles and SVGs when enabled, writing the
+// replacement Pug to output. It returns false when the node was not handled
+// and must be converted as usual.
+func (c *Convertor) Assets(node *html.Node, output *entities.IStringWriter) bool {
+	assetOptions := c.Options.ExtractAssets
+	if assetOptions == nil || c.Options.Assets == nil || util.HasAttr(node, "src") {
+		return false
+	}
+
+	switch strings.ToLower(node.Data) {
+	case "script":
+		if !assetOptions.Scripts || !isJavaScriptType(util.GetAttr(node, "type")) || c.sourceLanguage(node) {
+			return false
+		}
+		fileName, err := (*c.Options.Assets).Extract([]byte(textContent(node)), ".js")
+		if err != nil {
+			c.Diagnose(node, entities.WarningDiagnosticSeverity, entities.AssetDiagnosticCode, err.Error())
+			return false
+		}
+
+		script := *node
+		script.FirstChild, script.LastChild = nil, nil
+		script.Attr = append(append([]html.Attribute{}, node.Attr...), html.Attribute{Key: "src", Val: (*c.Options.Assets).URL(fileName)})
+		(*output).WriteLine((*c.Writer).TagHead(&script)+(*c.Writer).TagAttribute(&script, (*output).GetIndents()), true)
+		return true
+	case "style":
+		styleType := strings.ToLower(util.GetAttr(node, "type"))
+		if !assetOptions.Styles || (styleType != "" && styleType != "text/css") || c.sourceLanguage(node) {
+			return false
+		}
+		fileName, err := (*c.Options.Assets).Extract([]byte(textContent(node)), ".css")
+		if err != nil {
+			c.Diagnose(node, entities.WarningDiagnosticSeverity, entities.AssetDiagnosticCode, err.Error())
+			return false
+		}
+
+		link := &html.Node{
+			Type: html.ElementNode,
+			Data: "link",
+			Attr: []html.Attribute{
+				{Key: "rel", Val: "stylesheet"},
+				{Key: "href", Val: (*c.Options.Assets).URL(fileName)},
+			},
+		}
+		for _, attr := range node.Attr {
+			if attr.Key != "type" {
+				link.Attr = append(link.Attr, attr)
+			}
+		}
+		(*output).WriteLine((*c.Writer).TagHead(link)+(*c.Writer).TagAttribute(link, (*output).GetIndents()), true)
+		return true
+	case "svg":
+		if !assetOptions.SVGs {
+			return false
+		}
+		var buf bytes.Buffer
+		if err := html.Render(&buf, node); err != nil {
+			c.Diagnose(node, entities.WarningDiagnosticSeverity, entities.AssetDiagnosticCode, err.Error())
+			return false
+		}
+		fileName, err := (*c.Options.Assets).Extract(buf.Bytes(), ".svg")
+		if err != nil {
+			c.Diagnose(node, entities.WarningDiagnosticSeverity, entities.AssetDiagnosticCode, err.Error())
+			return false
+		}
+
+		(*output).WriteLine("include "+(*c.Options.Assets).Include(fileName), true)
+		return true
+	}
+
+	return false
+}
+
+// DataURIs returns a copy of node whose data: URI attributes point at
+// extracted files. The node itself is returned when nothing was rewritten.
+func (c *Convertor) DataURIs(node *html.Node) *html.Node {
+	if c.Options.ExtractAssets == nil || !c.Options.ExtractAssets.DataURIs || c.Options.Assets == nil {
+		return node
+	}
+
+	var attrs []html.Attribute
+	for i, attr := range node.Attr {
+		if !dataURIAttrs[attr.Key] {
+			continue
+		}
+		content, ext, ok := DecodeDataURI(strings.TrimSpace(attr.Val))
+		if !ok {
+			continue
+		}
+		fileName, err := (*c.Options.Assets).Extract(content, ext)
+		if err != nil {
+			c.Diagnose(node, entities.WarningDiagnosticSeverity, entities.AssetDiagnosticCode, err.Error())
+			continue
+		}
+		if attrs == nil {
+			attrs = append([]html.Attribute{}, node.Attr...)
+		}
+		attrs[i].Val = (*c.Options.Assets).URL(fileName)
+	}
+
+	if attrs == nil {
+		return node
+	}
+
+	rewritten := *node
+	rewritten.Attr = attrs
+	return &rewritten
+}
+
+func isJavaScriptType(scriptType string) bool {
+	switch strings.ToLower(strings.TrimSpace(scriptType)) {
+	case "", "module", "text/javascript", "application/javascript", "text/ecmascript", "application/ecmascript":
+		return true
+	}
+	return false
+}
+
+func textContent(node *html.Node) string {
+	var sb strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode {
+			sb.WriteString(child.Data)
+		}
+	}
+	return sb.String()
+}
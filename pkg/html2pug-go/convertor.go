@@ -8,21 +8,52 @@ import (
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
 	html "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
+var publicIdDocTypeNames = map[string]string{
+	"-//W3C//DTD XHTML 1.0 Transitional//EN": "transitional",
+	"-//W3C//DTD XHTML 1.0 Strict//EN":       "strict",
+	"-//W3C//DTD XHTML 1.0 Frameset//EN":     "frameset",
+	"-//W3C//DTD XHTML 1.1//EN":              "1.1",
+	"-//W3C//DTD XHTML Basic 1.1//EN":        "basic",
+	"-//WAPFORUM//DTD XHTML Mobile 1.2//EN":  "mobile",
+}
+
+var systemIdDocTypeNames = map[string]string{
+	"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd":       "transitional",
+	"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd":             "strict",
+	"http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd":           "frameset",
+	"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd":                  "1.1",
+	"http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd":            "basic",
+	"http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd": "mobile",
+}
+
 type Convertor struct {
 	Options              *entities.Html2JadeConvertorOptions
 	PublicIdDocTypeNames map[string]string
 	SystemIdDocTypeNames map[string]string
 	Writer               *entities.IWriter
+	// Selector is Options.Select compiled, see Selected
+	Selector *util.Selector
+
+	// The state of a conversion lives on the copy made by convert, so a
+	// Convertor can run several conversions at once
+	predicateTagHandlers []entities.PredicateTagHandler
+	tagHandlers          map[string][]entities.TagHandler
+	diagnostics          *[]entities.Diagnostic
+	document             *entities.Document
 }
 
 func NewConvertor(options *entities.Html2JadeConvertorOptions) (convertor entities.IConvertor) {
 
-	convertor = &Convertor{
-		Options: options,
-		Writer:  options.Writer,
+	c := &Convertor{
+		Options:              options,
+		PublicIdDocTypeNames: publicIdDocTypeNames,
+		SystemIdDocTypeNames: systemIdDocTypeNames,
+		Writer:               options.Writer,
 	}
+	convertor = c
 
 	return
 
@@ -30,12 +61,15 @@ func NewConvertor(options *entities.Html2JadeConvertorOptions) (convertor entiti
 
 // Comment implements entities.IConvertor.
 func (c *Convertor) Comment(node *html.Node, output *entities.IStringWriter) {
+	defer c.MapSource(node, output)()
+
 	// Match the condition pattern
 	re := regexp.MustCompile(`\s*\[(if\s+[^\]]+)\]`)
 	condition := re.FindStringSubmatch(node.Data)
 
 	if condition == nil {
 		// If no condition, handle as normal comment
+		c.Trace(node, entities.TraceEvent{Decision: entities.CommentTraceDecision})
 		data := node.Data
 		if len(data) == 0 || !strings.ContainsAny(data, "\r\n") {
 			// Output single-line comment
@@ -56,6 +90,9 @@ func (c *Convertor) Comment(node *html.Node, output *entities.IStringWriter) {
 		}
 	} else {
 		// If condition exists, process it
+		c.Diagnose(node, entities.InfoDiagnosticSeverity, entities.ConditionalDiagnosticCode,
+			fmt.Sprintf("conditional comment [%s] rewritten as a Pug comment", condition[1]))
+		c.Trace(node, entities.TraceEvent{Decision: entities.ConditionalTraceDecision, Detail: condition[1]})
 		c.Conditional(node, condition[1], output)
 	}
 
@@ -63,90 +100,212 @@ func (c *Convertor) Comment(node *html.Node, output *entities.IStringWriter) {
 
 // Conditional implements entities.IConvertor.
 func (c *Convertor) Conditional(node *html.Node, condition string, output *entities.IStringWriter) {
-	// Get the inner HTML (textContent in JS) and clean it up
+	// The inner HTML follows the "[if ...]>" marker, up to "<![endif]"
 	innerHTML := strings.TrimSpace(node.Data)
-	innerHTML = strings.Replace(innerHTML, " [if "+condition+"]> ", "", -1)
-	innerHTML = strings.Replace(innerHTML, "<![endif]", "", -1)
+	if i := strings.Index(innerHTML, "]>"); i >= 0 {
+		innerHTML = innerHTML[i+len("]>"):]
+	}
+	innerHTML = strings.TrimSpace(strings.TrimSuffix(innerHTML, "<![endif]"))
 
-	// Check if the inner HTML starts with a comment
+	// Downlevel-revealed comments, "[if ...]><!", leave their content outside
 	if strings.HasPrefix(innerHTML, "<!") {
 		condition = " [" + condition + "] <!"
-		innerHTML = "" // Set innerHTML to null if it starts with "<!"
+		innerHTML = ""
 	}
 
 	// Create the new conditional element
 	conditionalElem := &html.Node{
-		Type:       html.ElementNode,
-		Data:       "conditional", // Tag name 'conditional'
-		Attr:       []html.Attribute{{Key: "condition", Val: condition}},
-		FirstChild: nil, // Will be populated below if needed
+		Type: html.ElementNode,
+		Data: "conditional", // Tag name 'conditional'
+		Attr: []html.Attribute{{Key: "condition", Val: condition}},
 	}
 
-	// If there is inner HTML, we need to set it as children (text nodes)
+	// The inner HTML is parsed in the comment's context, e.g. head
 	if innerHTML != "" {
-		conditionalElem.FirstChild = &html.Node{
-			Type: html.TextNode,
-			Data: innerHTML,
+		context := node.Parent
+		if context == nil || context.Type != html.ElementNode {
+			context = &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		}
+		children, err := html.ParseFragment(strings.NewReader(innerHTML), context)
+		if err != nil {
+			c.Diagnose(node, entities.ErrorDiagnosticSeverity, entities.ConditionalDiagnosticCode, err.Error())
+		}
+		for _, child := range children {
+			conditionalElem.AppendChild(child)
+		}
+	}
+
+	// Convert the conditional element in place of the comment, leaving the
+	// tree untouched
+	c.Element(conditionalElem, output, false)
+}
+
+// DefaultFilters maps style and script languages to the Pug filters that
+// compile them. Markdown is left out, as a bare :markdown-it filter would
+// replace a <script type="text/markdown"> data block with rendered HTML.
+var DefaultFilters = map[string]entities.PugFilter{
+	"scss":                   {Name: "scss"},
+	"text/scss":              {Name: "scss"},
+	"text/x-scss":            {Name: "scss"},
+	"sass":                   {Name: "sass"},
+	"text/sass":              {Name: "sass"},
+	"text/x-sass":            {Name: "sass"},
+	"less":                   {Name: "less"},
+	"text/less":              {Name: "less"},
+	"text/x-less":            {Name: "less"},
+	"stylus":                 {Name: "stylus"},
+	"styl":                   {Name: "stylus"},
+	"text/stylus":            {Name: "stylus"},
+	"coffee":                 {Name: "coffee-script"},
+	"coffeescript":           {Name: "coffee-script"},
+	"text/coffeescript":      {Name: "coffee-script"},
+	"text/x-coffeescript":    {Name: "coffee-script"},
+	"ts":                     {Name: "typescript"},
+	"typescript":             {Name: "typescript"},
+	"text/typescript":        {Name: "typescript"},
+	"application/typescript": {Name: "typescript"},
+}
+
+// Filter returns the Pug filter for a style or script node, and the
+// attribute (lang or type) that selected it.
+func (c *Convertor) Filter(node *html.Node) (filter entities.PugFilter, attrKey string, ok bool) {
+	for _, key := range []string{"lang", "type"} {
+		value := strings.ToLower(strings.TrimSpace(util.GetAttr(node, key)))
+		if value == "" {
+			continue
+		}
+		if filter, ok = c.Options.Filters[value]; !ok {
+			filter, ok = DefaultFilters[value]
+		}
+		if ok && filter.Name != "" {
+			return filter, key, true
+		}
+	}
+	return entities.PugFilter{}, "", false
+}
+
+// Filtered writes the content of a style or script node through its Pug
+// filter, returning false when the node has none. In scalate mode plain
+// JavaScript and CSS use the scalateFilter (:javascript or :css).
+func (c *Convertor) Filtered(node *html.Node, output *entities.IStringWriter, tagHead string, scalateFilter string) bool {
+	filter, attrKey, ok := c.Filter(node)
+	if !ok {
+		if !c.Options.Scalate {
+			return false
+		}
+		filter = entities.PugFilter{Name: scalateFilter, Bare: true}
+	}
+
+	textOptions := entities.TextOptions{
+		Pipe: false,
+		Trim: true,
+		Wrap: false,
+	}
+
+	if filter.Bare {
+		(*output).WriteLine(":"+filter.Name, true)
+		(*c.Writer).WriteTextContent(node, output, textOptions)
+		return true
+	}
+
+	// The compiled content is plain CSS or JavaScript, so drop the attribute
+	// naming the source language.
+	filtered := *node
+	filtered.Attr = nil
+	for _, attr := range node.Attr {
+		if attr.Key != attrKey {
+			filtered.Attr = append(filtered.Attr, attr)
 		}
 	}
 
-	// Insert the new conditional element after the current node
-	node.Parent.AppendChild(conditionalElem)
+	(*output).WriteLine(tagHead+(*c.Writer).TagAttribute(&filtered, (*output).GetIndents()), true)
+	(*output).Enter()
+	(*output).WriteLine(":"+filter.Name, true)
+	(*c.Writer).WriteTextContent(node, output, textOptions)
+	(*output).Leave()
+	return true
 }
 
 // Script implements entities.IConvertor.
 func (c *Convertor) Script(node *html.Node, output *entities.IStringWriter, tagHead, tagAttr string) {
-	// Check if scalate flag is set (equivalent to this.scalate in JavaScript)
-	if c.Options.Scalate {
-		// If scalate is true, output ':javascript'
-		(*output).WriteLine(":javascript", true)
-
-		// Write the text content of the script node
-		(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
-			Pipe: false,
-			Wrap: false,
-		})
-	} else {
-		// If scalate is false, output the tag header and attributes followed by a period
-		(*output).WriteLine(tagHead+tagAttr+".", true)
-
-		// Write the text content of the script node with additional options
-		(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
-			Pipe:            false,
-			Trim:            true,
-			Wrap:            false,
-			EscapeBackslash: true,
-		})
+	if c.Filtered(node, output, tagHead, "javascript") {
+		return
 	}
+
+	// Otherwise, output the tag header and attributes followed by a period
+	(*output).WriteLine(tagHead+tagAttr+".", true)
+
+	// Write the text content of the script node with additional options
+	(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
+		Pipe:                false,
+		Trim:                true,
+		Wrap:                false,
+		EscapeBackslash:     true,
+		EscapeInterpolation: true,
+	})
 }
 
 // Style implements entities.IConvertor.
 func (c *Convertor) Style(node *html.Node, output *entities.IStringWriter, tagHead string, tagAttr string) {
-	if c.Options.Scalate {
-		// In scalate mode, emit shorthand for embedded CSS
-		(*output).WriteLine(":css", true)
-		(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
-			Pipe: false,
-			Wrap: false,
-		})
-	} else {
-		// Otherwise, output full tag and its content
-		(*output).WriteLine(tagHead+tagAttr+".", true)
-		(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
-			Pipe: false,
-			Trim: true,
-			Wrap: false,
-		})
+	if c.Filtered(node, output, tagHead, "css") {
+		return
 	}
+
+	// Otherwise, output full tag and its content
+	(*output).WriteLine(tagHead+tagAttr+".", true)
+	(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
+		Pipe:                false,
+		Trim:                true,
+		Wrap:                false,
+		EscapeInterpolation: true,
+	})
 }
 
 // Text implements entities.IConvertor.
 func (c *Convertor) Text(node *html.Node, output *entities.IStringWriter, textOptions entities.TextOptions) {
-	util.NormalizeTextNode(node)
 	(*c.Writer).WriteText(node, output, textOptions)
 }
 
+// Document implements entities.IConvertor.
 func (c *Convertor) Document(document *entities.Document, output *entities.IStringWriter) {
+	c.convert(document, nil, output)
+}
+
+// DocumentWithDiagnostics implements entities.IDiagnosticConvertor.
+func (c *Convertor) DocumentWithDiagnostics(document *entities.Document, output *entities.IStringWriter) []entities.Diagnostic {
+	var diagnostics []entities.Diagnostic
+	c.convert(document, &diagnostics, output)
+	return diagnostics
+}
+
+// convert converts document on a copy of c holding the conversion state.
+// Diagnostics are collected into diagnostics, unless it is nil.
+func (c *Convertor) convert(document *entities.Document, diagnostics *[]entities.Diagnostic, output *entities.IStringWriter) {
+	conversion := *c
+	conversion.document = document
+	conversion.diagnostics = diagnostics
+	// The built-in handlers are bound to the copy
+	conversion.predicateTagHandlers = conversion.defaultPredicateTagHandlers()
+	conversion.tagHandlers = conversion.defaultTagHandlers()
+	// So is the writer, to trace the document's positions
+	if writer, ok := (*c.Writer).(*Writer); ok {
+		writerCopy := *writer
+		writerCopy.document = document
+		var documentWriter entities.IWriter = &writerCopy
+		conversion.Writer = &documentWriter
+	}
+	conversion.convertDocument(output)
+}
+
+// convertDocument converts the document of the conversion in progress.
+func (c *Convertor) convertDocument(output *entities.IStringWriter) {
+	document := c.document
+
+	if c.Selector != nil {
+		c.Selected(document, c.Selector, output)
+		return
+	}
+
 	var docTypeName string
 	docType := document.GetDocType()
 	// Traverse to find the DoctypeNode
@@ -165,6 +324,9 @@ func (c *Convertor) Document(document *entities.Document, output *entities.IStri
 			}
 		} else if docType.Name != "" && strings.ToLower(docType.Name) == "html" {
 			docTypeName = "html"
+		} else if docType.Name == "xml" {
+			// XML prolog, see XMLParser
+			docTypeName = "xml"
 		}
 
 		if docTypeName != "" {
@@ -179,9 +341,218 @@ func (c *Convertor) Document(document *entities.Document, output *entities.IStri
 		htmlEls := document.GetElementsByTagName("html")
 		if len(htmlEls) > 0 {
 			c.Element(htmlEls[0], output, false)
+		} else {
+			// XHTML fragments (e.g. JSF compositions) have no html element
+			c.Children(document.Root, output, false)
+		}
+	}
+
+}
+
+// Diagnose records a diagnostic about node for the conversion in progress,
+// when diagnostics are collected.
+func (c *Convertor) Diagnose(node *html.Node, severity entities.DiagnosticSeverity, code string, message string) {
+	if c.diagnostics == nil {
+		return
+	}
+	diagnostic := entities.Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  message,
+		Path:     util.NodePath(node),
+	}
+	if c.document != nil {
+		diagnostic.Position, _ = c.document.Position(node)
+	}
+	*c.diagnostics = append(*c.diagnostics, diagnostic)
+}
+
+// Selected converts only the subtrees matching selector, in document order,
+// optionally inside the Options.SelectBlock block. Matches inside an already
+// selected subtree are not repeated.
+func (c *Convertor) Selected(document *entities.Document, selector *util.Selector, output *entities.IStringWriter) {
+	nodes := document.Select(selector)
+
+	var roots []*html.Node
+	for _, node := range nodes {
+		nested := false
+		for _, root := range roots {
+			if util.IsAncestor(root, node) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			roots = append(roots, node)
+		}
+	}
+	if len(roots) == 0 {
+		c.Diagnose(document.Root, entities.WarningDiagnosticSeverity, entities.SelectDiagnosticCode,
+			fmt.Sprintf("no element matches %q", selector))
+		return
+	}
+
+	if c.Options.SelectBlock != "" {
+		(*output).WriteLine("block "+c.Options.SelectBlock, true)
+		(*output).Enter()
+		defer (*output).Leave()
+	}
+	for _, root := range roots {
+		c.Element(root, output, false)
+	}
+}
+
+// Trace passes a conversion decision for node to the tracer, if any.
+func (c *Convertor) Trace(node *html.Node, event entities.TraceEvent) {
+	traceNode(c.Options, c.document, node, event)
+}
+
+// MapSource points a source mapping output at the position of node until the
+// returned function restores the previous one.
+func (c *Convertor) MapSource(node *html.Node, output *entities.IStringWriter) (restore func()) {
+	sourceMapper, ok := (*output).(entities.ISourceMapper)
+	if !ok || c.document == nil {
+		return func() {}
+	}
+
+	previous := sourceMapper.GetSource()
+	if position, ok := c.document.Position(node); ok {
+		sourceMapper.SetSource(&position)
+	}
+	return func() {
+		sourceMapper.SetSource(previous)
+	}
+}
+
+// Shorthand returns the id and classes of an element's tag head that use
+// the Pug shorthand.
+func (c *Convertor) Shorthand(node *html.Node) (shorthand []string) {
+	if id := util.GetAttr(node, "id"); id != "" && util.IsValidJadeId(id) {
+		shorthand = append(shorthand, "#"+id)
+	}
+
+	if writer, ok := (*c.Writer).(*Writer); ok {
+		classNames, _, _ := writer.Classes(util.GetAttr(node, "class"))
+		for _, name := range classNames {
+			shorthand = append(shorthand, "."+name)
+		}
+	}
+
+	return
+}
+
+// DiagnoseTagHead records diagnostics for the parts of an element's tag head
+// that cannot use the Pug shorthand, and for the attribute values Pug keeps
+// literally. The handlers writing the tag head call it.
+func (c *Convertor) DiagnoseTagHead(node *html.Node) {
+	if !util.IsValidJadeTagName(node.Data) {
+		c.Diagnose(node, entities.InfoDiagnosticSeverity, entities.TagInterpolationDiagnosticCode,
+			fmt.Sprintf("tag name %q written with #{} interpolation", node.Data))
+	}
+
+	if id := util.GetAttr(node, "id"); util.HasAttr(node, "id") && !util.IsValidJadeId(id) {
+		c.Diagnose(node, entities.InfoDiagnosticSeverity, entities.IdAttributeDiagnosticCode,
+			fmt.Sprintf("id %q is not valid shorthand, written as an attribute", id))
+	}
+
+	var invalidClassNames []string
+	for _, name := range strings.Fields(util.GetAttr(node, "class")) {
+		if !util.IsValidJadeClassName(name) {
+			invalidClassNames = append(invalidClassNames, name)
+		}
+	}
+	if len(invalidClassNames) > 0 && (c.Options.WriterOptions == nil || c.Options.WriterOptions.ClassMode == entities.SplitClassMode) {
+		c.Diagnose(node, entities.InfoDiagnosticSeverity, entities.ClassAttributeDiagnosticCode,
+			fmt.Sprintf("class names %q are not valid shorthand, written as a class attribute after the others", strings.Join(invalidClassNames, " ")))
+	}
+
+	c.AttributeInterpolation(node)
+}
+
+// AttributeInterpolation records a diagnostic for the attribute values of
+// node holding Pug interpolation syntax. Pug no longer interpolates
+// attribute values, so they are written literally.
+func (c *Convertor) AttributeInterpolation(node *html.Node) {
+	for _, attr := range node.Attr {
+		if util.EscapeInterpolation(attr.Val) != attr.Val {
+			c.Diagnose(node, entities.InfoDiagnosticSeverity, entities.AttributeInterpolationDiagnosticCode,
+				fmt.Sprintf("attribute %q value %q written literally, Pug does not interpolate attribute values", attr.Key, attr.Val))
+		}
+	}
+}
+
+// Unrepresentable returns why an element cannot be written as Pug, or an
+// empty string when it can.
+func (c *Convertor) Unrepresentable(node *html.Node) string {
+	for _, attr := range node.Attr {
+		if !util.IsRepresentableAttributeName(attr.Key) {
+			return fmt.Sprintf("attribute name %q cannot be written in Pug", attr.Key)
+		}
+	}
+	return ""
+}
+
+// RawHTML writes node as raw HTML, which Pug passes through unchanged: a
+// single line starting with "<", or piped lines when the HTML spans several.
+func (c *Convertor) RawHTML(node *html.Node, output *entities.IStringWriter, reason string) {
+	var raw strings.Builder
+	if err := html.Render(&raw, node); err != nil {
+		c.Diagnose(node, entities.ErrorDiagnosticSeverity, entities.RawHTMLDiagnosticCode, err.Error())
+		return
+	}
+
+	c.Diagnose(node, entities.WarningDiagnosticSeverity, entities.RawHTMLDiagnosticCode,
+		fmt.Sprintf("<%s> written as raw HTML: %s", node.Data, reason))
+
+	lines := strings.Split(strings.ReplaceAll(raw.String(), "\r\n", "\n"), "\n")
+	if len(lines) == 1 {
+		(*output).WriteLine(util.EscapeInterpolation(lines[0]), true)
+		return
+	}
+	for _, line := range lines {
+		// A bare pipe keeps blank lines, e.g. inside <pre> or <textarea>
+		if line == "" {
+			(*output).WriteLine("|", true)
+			continue
 		}
+		(*output).WriteLine("| "+util.EscapeInterpolation(line), true)
+	}
+}
+
+// Noscript returns a copy of a <noscript> node whose raw text content has been
+// re-parsed as markup when the FragmentNoscriptMode is set. Any other node is
+// returned unchanged.
+func (c *Convertor) Noscript(node *html.Node) *html.Node {
+	if c.Options.Noscript != entities.FragmentNoscriptMode || node.DataAtom != atom.Noscript {
+		return node
+	}
+
+	var raw strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.TextNode {
+			// Already parsed as markup, e.g. with scripting disabled
+			return node
+		}
+		raw.WriteString(child.Data)
+	}
+
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	fragment, err := html.ParseFragmentWithOptions(strings.NewReader(raw.String()), context, html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return node
 	}
 
+	noscript := &html.Node{
+		Type:      node.Type,
+		DataAtom:  node.DataAtom,
+		Data:      node.Data,
+		Namespace: node.Namespace,
+		Attr:      node.Attr,
+	}
+	for _, child := range fragment {
+		noscript.AppendChild(child)
+	}
+	return noscript
 }
 
 // Placeholder methods for Children and Element
@@ -196,17 +567,27 @@ func (c *Convertor) Children(parent *html.Node, output *entities.IStringWriter,
 		case html.ElementNode:
 			c.Element(child, output, false)
 		case html.TextNode:
-			// Check if the parent is a <code> element
-			if strings.ToLower(parent.Data) == "code" {
-				c.Text(child, output, entities.TextOptions{
+			// Transforms such as unwrapping can leave adjacent text nodes
+			text, last := util.MergeTextNodes(child)
+			child = last
+			// Check if the parent is a <code> element. Text written at the
+			// root is piped too, Pug would read it as a tag otherwise
+			if strings.ToLower(parent.Data) == "code" || (*output).GetIndents() == "" || c.Options.TextLayout != entities.DefaultTextLayout {
+				c.Text(text, output, entities.TextOptions{
 					EncodeEntityRef: true,
 					Pipe:            true,
 				})
 			} else {
-				c.Text(child, output, entities.TextOptions{
+				c.Text(text, output, entities.TextOptions{
 					EncodeEntityRef: true, // set to false if you want doNotEncode behavior
 				})
 			}
+		case html.RawNode:
+			// CDATA sections and processing instructions, see XMLParser
+			c.Text(child, output, entities.TextOptions{
+				Pipe:                true,
+				EscapeInterpolation: true,
+			})
 		case html.CommentNode:
 			c.Comment(child, output)
 		}
@@ -221,72 +602,95 @@ func (c *Convertor) Element(node *html.Node, output *entities.IStringWriter, doN
 	if node == nil || node.Type != html.ElementNode {
 		return
 	}
+	defer c.MapSource(node, output)()
+
+	source := node
+	shorthand := c.Shorthand(node)
+	node = c.DataURIs(node)
+	node = c.Noscript(node)
+
+	convertor := entities.IConvertor(c)
+	c.Handle(&entities.TagContext{
+		Node:        node,
+		Source:      source,
+		TagName:     strings.ToLower(node.Data),
+		TagHead:     (*c.Writer).TagHead(node),
+		TagAttr:     (*c.Writer).TagAttribute(node, (*output).GetIndents()),
+		TagText:     (*c.Writer).TagText(node),
+		Shorthand:   shorthand,
+		DoNotEncode: doNotEncode,
+		Output:      output,
+		Writer:      c.Writer,
+		Convertor:   &convertor,
+	})
 
-	tagName := strings.ToLower(node.Data)
-	tagHead := (*c.Writer).TagHead(node)
-	tagAttr := (*c.Writer).TagAttribute(node, (*output).GetIndents())
-	tagText := (*c.Writer).TagText(node)
+	// (*output).WriteLine(fmt.Sprintf("Processing element: %v", el.Type), entities.DoIndent)
+}
 
-	switch tagName {
-	case "script", "style":
-		if util.HasAttr(node, "src") {
-			(*output).WriteLine(tagHead+tagAttr, true)
-			(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
-				Pipe: false,
-				Wrap: false,
-			})
-		} else if tagName == "script" {
-			c.Script(node, output, tagHead, tagAttr)
-		} else if tagName == "style" {
-			c.Style(node, output, tagHead, tagAttr)
-		}
-	case "conditional":
-		cond := util.GetAttr(node, "condition")
-		(*output).WriteLine("//"+cond, true)
-		c.Children(node, output, true)
-	case "pre":
-		(*output).WriteLine(tagHead+tagAttr+".", true)
-		(*output).Enter()
-		firstLine := true
-		for child := node.FirstChild; child != nil; child = child.NextSibling {
-			if child.Type == html.TextNode {
-				data := child.Data
-				if strings.TrimSpace(data) != "" {
-					if firstLine {
-						data = strings.TrimLeft(data, "\r\n")
-						data = "\\n" + data
-						firstLine = false
-					}
-					data = strings.ReplaceAll(data, "\t", "\\t")
-					data = strings.ReplaceAll(data, "\r\n", "\n"+(*output).GetIndents())
-					data = strings.ReplaceAll(data, "\r", "\n"+(*output).GetIndents())
-					data = strings.ReplaceAll(data, "\n", "\n"+(*output).GetIndents())
-					(*output).Write(data, true)
-				}
-			}
-		}
-		(*output).WriteLine("", true)
-		(*output).Leave()
-	default:
-		if c.Options.Bodyless && (tagName == "html" || tagName == "body") {
-			// bodyless in options, skip the output and jump to next
-			c.Children(node, output, false)
-		} else if !c.Options.KeepHead && (tagName == "head") {
-			// headless in options, skip the children of head
-			// c.Children(node, output, false)
-		} else if tagText != nil {
-			if doNotEncode {
-				(*output).WriteLine(tagHead+tagAttr+" "+*tagText, true)
-			} else {
-				(*output).WriteLine(tagHead+tagAttr+" "+html.EscapeString(*tagText), true)
+// inlineElements are the phrasing elements kept as HTML inside block text.
+var inlineElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Bdi: true, atom.Bdo: true,
+	atom.Br: true, atom.Cite: true, atom.Code: true, atom.Data: true, atom.Dfn: true,
+	atom.Em: true, atom.I: true, atom.Kbd: true, atom.Mark: true, atom.Q: true,
+	atom.S: true, atom.Samp: true, atom.Small: true, atom.Span: true, atom.Strong: true,
+	atom.Sub: true, atom.Sup: true, atom.Time: true, atom.U: true, atom.Var: true,
+	atom.Wbr: true,
+}
+
+var blockTextEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+)
+
+// BlockText returns the lines of node's content to be written as dot block
+// text under the selected TextLayout, or nil when the content should be
+// converted as piped text and nested tags.
+func (c *Convertor) BlockText(node *html.Node) (lines []string) {
+	layout := c.Options.TextLayout
+	if layout != entities.BlockTextLayout && layout != entities.AutoTextLayout || node.DataAtom == atom.Textarea {
+		return nil
+	}
+
+	var content strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case child.Type == html.TextNode:
+			content.WriteString(util.EscapeInterpolation(blockTextEscaper.Replace(child.Data)))
+		case child.Type == html.ElementNode && layout == entities.BlockTextLayout && inlineElements[child.DataAtom]:
+			var inline strings.Builder
+			if err := html.Render(&inline, child); err != nil {
+				return nil
 			}
-		} else {
-			(*output).WriteLine(tagHead+tagAttr, true)
-			c.Children(node, output, true)
+			content.WriteString(util.EscapeInterpolation(inline.String()))
+		default:
+			return nil
 		}
 	}
 
-	// (*output).WriteLine(fmt.Sprintf("Processing element: %v", el.Type), entities.DoIndent)
+	// Whitespace is insignificant in prose, so the text is reflowed to WrapLength
+	lines = (*c.Writer).BreakLine(strings.Join(strings.Fields(content.String()), " "))
+
+	if len(lines) == 0 || layout == entities.AutoTextLayout && len(lines) < 2 {
+		return nil
+	}
+	return lines
+}
+
+// isRawContent reports whether the children of node are CDATA sections
+// (RawNodes), optionally separated by whitespace, which convert to block text.
+func isRawContent(node *html.Node) bool {
+	hasRaw := false
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case child.Type == html.RawNode:
+			hasRaw = true
+		case child.Type == html.TextNode && strings.TrimSpace(child.Data) == "":
+		default:
+			return false
+		}
+	}
+	return hasRaw
 }
 
 func GetElementsByTagName(doc *entities.Document, tag string) []*entities.Element {
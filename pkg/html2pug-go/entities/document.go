@@ -1,21 +1,118 @@
 package entities
 
-import "golang.org/x/net/html"
+import (
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	"golang.org/x/net/html"
+)
 
 type Document struct {
 	Doctype         *Doctype
 	DocumentElement *Element
 	Root            *html.Node
+	// Positions holds the source location of the parsed nodes, where the
+	// parser could track it.
+	Positions map[*html.Node]Position
+
+	locator func() map[*html.Node]Position
+}
+
+// Position returns the source location of node.
+func (d *Document) Position(node *html.Node) (position Position, ok bool) {
+	position, ok = d.Positions[node]
+	return
+}
+
+// SetLocator sets how Locate computes the source locations of the parsed
+// nodes, for parsers that only track them on demand.
+func (d *Document) SetLocator(locator func() map[*html.Node]Position) {
+	d.locator = locator
 }
 
+// Locate returns Positions, computing them with the locator when they were
+// not tracked while parsing. It must run before the tree is changed.
+func (d *Document) Locate() map[*html.Node]Position {
+	if d.Positions == nil && d.locator != nil {
+		return d.locator()
+	}
+	return d.Positions
+}
+
+// GetDocType returns the doctype node of the document, if any, with its
+// public and system identifiers.
 func (d *Document) GetDocType() (docType *Doctype) {
-	for n := d.Root; n != nil; n = n.NextSibling {
+	if d.Root == nil {
+		return d.Doctype
+	}
+	for n := d.Root.FirstChild; n != nil; n = n.NextSibling {
 		if n.Type == html.DoctypeNode {
-			d.Doctype = &Doctype{}
+			d.Doctype = &Doctype{Name: n.Data}
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "public":
+					d.Doctype.PublicId = attr.Val
+				case "system":
+					d.Doctype.SystemId = attr.Val
+				}
+			}
+			break
 		}
 	}
 	return d.Doctype
 }
+
+// QuerySelector returns the first element matching the CSS selector list in
+// document order, or nil. See util.CompileSelector for the syntax.
+func (d *Document) QuerySelector(selector string) (*html.Node, error) {
+	compiled, err := util.CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	nodes := d.querySelectorAll(compiled, true)
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	return nodes[0], nil
+}
+
+// QuerySelectorAll returns the elements matching the CSS selector list in
+// document order.
+func (d *Document) QuerySelectorAll(selector string) ([]*html.Node, error) {
+	compiled, err := util.CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return d.querySelectorAll(compiled, false), nil
+}
+
+// Select returns the elements matching a compiled selector in document
+// order.
+func (d *Document) Select(selector *util.Selector) []*html.Node {
+	return d.querySelectorAll(selector, false)
+}
+
+func (d *Document) querySelectorAll(selector *util.Selector, first bool) (nodes []*html.Node) {
+	var traverse func(*html.Node) bool
+	traverse = func(n *html.Node) bool {
+		if selector.Match(n) {
+			nodes = append(nodes, n)
+			if first {
+				return true
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if traverse(c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if d.Root != nil {
+		traverse(d.Root)
+	}
+	return
+}
+
 func (d *Document) GetElementsByTagName(tagName string) (nodes []*html.Node) {
 	var traverse func(*html.Node)
 	traverse = func(n *html.Node) {
@@ -32,3 +129,42 @@ func (d *Document) GetElementsByTagName(tagName string) (nodes []*html.Node) {
 	}
 	return
 }
+
+// Transform runs each transform over the whole tree in turn. When Enter
+// removes or unwraps a node, the nodes that took its place are visited next
+// and Leave is not called for it.
+func (d *Document) Transform(transforms ...Transform) {
+	if d.Root == nil {
+		return
+	}
+	for _, transform := range transforms {
+		transformNode(d.Root, transform)
+	}
+}
+
+func transformNode(node *html.Node, transform Transform) {
+	parent := node.Parent
+	if transform.Enter != nil {
+		transform.Enter(node)
+	}
+	if node.Parent != parent {
+		return
+	}
+
+	var previous *html.Node
+	for child := node.FirstChild; child != nil; {
+		transformNode(child, transform)
+		if child.Parent == node {
+			previous = child
+		}
+		if previous != nil {
+			child = previous.NextSibling
+		} else {
+			child = node.FirstChild
+		}
+	}
+
+	if transform.Leave != nil {
+		transform.Leave(node)
+	}
+}
@@ -1,5 +1,11 @@
 package entities
 
+import (
+	"fmt"
+
+	"golang.org/x/net/html"
+)
+
 type Window struct {
 	Document *Document
 }
@@ -19,14 +25,131 @@ type ProgramInputType string
 const (
 	HTMLProgramInputType ProgramInputType = "html"
 	URLProgramInputType  ProgramInputType = "url"
+	// XHTMLProgramInputType parses the input as XML, see XMLParser
+	XHTMLProgramInputType ProgramInputType = "xhtml"
+)
+
+// NoscriptMode selects how <noscript> content is converted.
+type NoscriptMode string
+
+const (
+	// RawNoscriptMode keeps the html.Parse default, where scripting is
+	// enabled and noscript content is a single raw text node.
+	RawNoscriptMode NoscriptMode = ""
+	// DisableScriptingNoscriptMode parses the whole document with scripting
+	// disabled, so noscript content is parsed as markup.
+	DisableScriptingNoscriptMode NoscriptMode = "disable-scripting"
+	// FragmentNoscriptMode re-parses the raw noscript text as an HTML
+	// fragment when converting it.
+	FragmentNoscriptMode NoscriptMode = "fragment"
+)
+
+// ClassMode selects how class names are split between the `.class`
+// shorthand and the class attribute.
+type ClassMode string
+
+const (
+	// SplitClassMode puts valid class names in the shorthand and the others
+	// in a class attribute, which may reorder them.
+	SplitClassMode ClassMode = ""
+	// ArrayClassMode writes all class names as a class array, e.g.
+	// class=['md:flex', 'w-1/2'], when any of them is not valid shorthand,
+	// keeping the source order.
+	ArrayClassMode ClassMode = "array"
+	// TailwindClassMode keeps the shorthand for the leading valid class
+	// names when the rest can follow in a class array without reordering,
+	// and uses a single class array otherwise.
+	TailwindClassMode ClassMode = "tailwind"
 )
 
 type WriterOptions struct {
-	WrapLength  *int
-	Scalate     *bool
-	NoAttrComma *bool
-	Double      *bool
-	NoEmptyPipe *bool
+	WrapLength *int
+	// InlineTextLength is the longest text, in display columns, kept on the
+	// same line as its tag. Defaults to WrapLength.
+	InlineTextLength *int
+	Scalate          *bool
+	NoAttrComma      *bool
+	Double           *bool
+	NoEmptyPipe      *bool
+	// WrapAttributes puts one attribute per line when the tag line would
+	// exceed WrapLength.
+	WrapAttributes *bool
+	// TemplateLiterals writes multi-line attribute values as ES2015
+	// template literals keeping their real newlines.
+	TemplateLiterals *bool
+	// ExplicitBooleanAttributes keeps the values of boolean attributes,
+	// e.g. disabled='disabled', for XHTML-style output.
+	ExplicitBooleanAttributes *bool
+	// StyleObjects writes style attributes as Pug style objects, e.g.
+	// style={color: 'red'}, falling back to a string when the CSS cannot
+	// be parsed cleanly.
+	StyleObjects *bool
+	// DataObjects writes data-* attributes holding a JSON object or array
+	// as JavaScript literals, e.g. data-config={a: 1}.
+	DataObjects *bool
+	// ClassMode selects how class names Pug cannot write as `.class`
+	// shorthand are written. Defaults to SplitClassMode.
+	ClassMode ClassMode
+}
+
+// TextLayout selects how text content that does not fit after its tag is
+// written.
+type TextLayout string
+
+const (
+	// DefaultTextLayout writes text lines as they were converted so far,
+	// piped only inside code elements and at the root, where a bare line
+	// would read as a tag.
+	DefaultTextLayout TextLayout = ""
+	// PipeTextLayout always writes text as piped lines.
+	PipeTextLayout TextLayout = "pipe"
+	// BlockTextLayout writes elements containing only text and inline
+	// elements as dot block text, e.g. `p.`, with inline elements as HTML.
+	BlockTextLayout TextLayout = "block"
+	// AutoTextLayout writes text-only elements spanning several lines as
+	// block text, and pipes everything else.
+	AutoTextLayout TextLayout = "auto"
+)
+
+// LineEnding is the line terminator used in the Pug output.
+type LineEnding string
+
+const (
+	LFLineEnding   LineEnding = "\n"
+	CRLFLineEnding LineEnding = "\r\n"
+)
+
+// OutputOptions controls the encoding of the final Pug output.
+type OutputOptions struct {
+	// LineEnding defaults to LFLineEnding
+	LineEnding LineEnding
+	// BOM prefixes the output with a UTF-8 byte order mark
+	BOM bool
+	// FinalNewline guarantees non-empty output ends with a line ending
+	FinalNewline bool
+}
+
+// PugFilter describes the Pug filter used for a style or script language.
+type PugFilter struct {
+	Name string
+	// Bare emits the filter on its own instead of nested in the original tag,
+	// for filters that render markup rather than CSS or JavaScript.
+	Bare bool
+}
+
+// AssetOptions controls which inline assets are extracted into
+// OutDirectoryPath instead of being emitted inline.
+type AssetOptions struct {
+	Scripts  bool
+	Styles   bool
+	SVGs     bool
+	DataURIs bool
+	// Directory is the sub-directory of OutDirectoryPath the assets are
+	// written to, and the path used by `include` for SVGs. Defaults to "assets".
+	Directory string
+	// URLPrefix is prepended to the file name in rewritten src/href values.
+	// Defaults to Directory + "/".
+	URLPrefix string
 }
 
 type Html2JadeConvertorOptions struct {
@@ -38,14 +161,241 @@ type Html2JadeConvertorOptions struct {
 	WriterOptions    *WriterOptions
 	InputType        ProgramInputType
 	OutDirectoryPath string
+	Noscript         NoscriptMode
+	TextLayout       TextLayout
+	// DetectCharset decodes the input using its BOM, meta charset or the
+	// ContentType hint (e.g. an HTTP Content-Type header) instead of
+	// assuming UTF-8. XHTML input uses the prolog encoding in place of the
+	// meta charset.
+	DetectCharset bool
+	ContentType   string
+	OutputOptions *OutputOptions
+	ExtractAssets *AssetOptions
+	// Filters maps a lower-cased lang attribute or type MIME to the Pug
+	// filter used for the style or script content. Entries override
+	// DefaultFilters; an entry with an empty Name disables the filter.
+	Filters map[string]PugFilter
+	// TagHandlers maps lower-cased tag names to handlers converting those
+	// elements. Entries override the built-in handlers of the Convertor.
+	TagHandlers map[string]TagHandler
+	// PredicateTagHandlers are tried in order before TagHandlers.
+	PredicateTagHandlers []PredicateTagHandler
+	// Rules are applied in order on the parsed document before Transforms,
+	// see LoadTransformRules.
+	Rules []TransformRule
+	// Transforms run in order on the parsed document before it is
+	// converted, see Document.Transform.
+	Transforms []Transform
+	// Select is a CSS selector list; when set only the matching subtrees
+	// are converted, as sibling Pug roots without a doctype. It requires the
+	// built-in Converter, other Converters fail the conversion.
+	Select string
+	// SelectBlock wraps the selected subtrees in a named Pug block. It is
+	// ignored without Select.
+	SelectBlock string
 
 	Parser    *IParser
 	Converter *IConvertor
 	Output    *IStringWriter
 	Writer    *IWriter
+	Assets    *IAssetExtractor
+	// Tracer receives the conversion decisions taken for each node
+	Tracer *ITracer
+}
+
+// NodeAction is one step of a Transform. It may modify, move or remove
+// node.
+type NodeAction func(node *html.Node)
+
+// Transform visits every node of a parsed document, calling Enter before
+// the node's children and Leave after them.
+type Transform struct {
+	Enter NodeAction
+	Leave NodeAction
+}
+
+// TransformRule applies its actions to the elements matching the Select
+// CSS selector. Attribute and class names in RemoveAttr and RemoveClass may
+// be glob patterns, e.g. data-track-* or js-*, see util.CompileGlob. An
+// attribute renamed by RenameAttr replaces any attribute of its new name.
+type TransformRule struct {
+	Select      string            `yaml:"select"`
+	Remove      bool              `yaml:"remove,omitempty"`
+	Unwrap      bool              `yaml:"unwrap,omitempty"`
+	Rename      string            `yaml:"rename,omitempty"`
+	RenameAttr  map[string]string `yaml:"rename-attr,omitempty"`
+	SetAttr     map[string]string `yaml:"set-attr,omitempty"`
+	RemoveAttr  []string          `yaml:"remove-attr,omitempty"`
+	AddClass    []string          `yaml:"add-class,omitempty"`
+	RemoveClass []string          `yaml:"remove-class,omitempty"`
+}
+
+// TransformRuleFile is the YAML format of a rules file.
+type TransformRuleFile struct {
+	Rules []TransformRule `yaml:"rules"`
 }
+
+// TagContext holds an element being converted and what a TagHandler needs
+// to write it.
+type TagContext struct {
+	// Node is the element, possibly a rewritten copy of Source
+	Node *html.Node
+	// Source is the element as parsed, for positions and diagnostics
+	Source      *html.Node
+	TagName     string
+	TagHead     string
+	TagAttr     string
+	TagText     *string
+	Shorthand   []string
+	DoNotEncode bool
+	Output      *IStringWriter
+	Writer      *IWriter
+	Convertor   *IConvertor
+	// Next runs the handler this one replaced, e.g. the built-in one
+	Next func()
+}
+
+type TagHandler func(ctx *TagContext)
+
+// PredicateTagHandler handles the elements Match accepts.
+type PredicateTagHandler struct {
+	Match  func(node *html.Node) bool
+	Handle TagHandler
+}
+
+// SourceMapOptions names the files of a source map built by
+// ConvertHTMLWithSourceMap.
+type SourceMapOptions struct {
+	// File is the name of the generated Pug file
+	File string
+	// Source is the name of the HTML file
+	Source string
+	// SourcesContent embeds the HTML in the source map
+	SourcesContent bool
+}
+
+// SourceMapping maps the start of a Pug output segment to the HTML source.
+// GeneratedLine and GeneratedColumn are 0-based, as in Source Map v3.
+type SourceMapping struct {
+	GeneratedLine   int
+	GeneratedColumn int
+	Source          Position
+}
+
+// SourceMap is a Source Map v3 document.
+type SourceMap struct {
+	Version        int      `json:"version"`
+	File           string   `json:"file,omitempty"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent,omitempty"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+}
+
+// TraceDecision names the branch the convertor took for a node.
+type TraceDecision string
+
+const (
+	InlineTextTraceDecision    TraceDecision = "inline-text"
+	BlockTextTraceDecision     TraceDecision = "block-text"
+	RawContentTraceDecision    TraceDecision = "raw-content"
+	ChildrenTraceDecision      TraceDecision = "children"
+	PreTraceDecision           TraceDecision = "pre"
+	ScriptTraceDecision        TraceDecision = "script"
+	StyleTraceDecision         TraceDecision = "style"
+	ExternalTraceDecision      TraceDecision = "external"
+	BodylessSkipTraceDecision  TraceDecision = "bodyless-skip"
+	HeadSkipTraceDecision      TraceDecision = "head-skip"
+	ConditionalTraceDecision   TraceDecision = "conditional"
+	CommentTraceDecision       TraceDecision = "comment"
+	RawHTMLTraceDecision       TraceDecision = "raw-html"
+	AssetTraceDecision         TraceDecision = "asset"
+	TrimTextTraceDecision      TraceDecision = "trim-text"
+	DropEmptyPipeTraceDecision TraceDecision = "drop-empty-pipe"
+	CustomHandlerTraceDecision TraceDecision = "custom-handler"
+)
+
+// TraceEvent records one conversion decision for a node.
+type TraceEvent struct {
+	Decision TraceDecision `json:"decision"`
+	// Node is the tag name, or #text and #comment
+	Node     string   `json:"node"`
+	Path     string   `json:"path"`
+	Position Position `json:"position"`
+	// Shorthand lists the id and classes written in the tag head, e.g. #main .nav
+	Shorthand []string `json:"shorthand,omitempty"`
+	// Text is the text before trimming
+	Text   string `json:"text,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type DiagnosticSeverity string
+
+const (
+	InfoDiagnosticSeverity    DiagnosticSeverity = "info"
+	WarningDiagnosticSeverity DiagnosticSeverity = "warning"
+	ErrorDiagnosticSeverity   DiagnosticSeverity = "error"
+)
+
+// Position is the location of a node in the HTML source. Line and Column
+// are 1-based, Column counting characters.
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Diagnostic reports a part of the document that was converted lossily or
+// in a non-idiomatic way. Position is zero for nodes without a source
+// location, such as elements implied by the parser.
+type Diagnostic struct {
+	Severity DiagnosticSeverity
+	Code     string
+	Message  string
+	Position Position
+	// Path locates the node in the parsed tree, e.g. /html[1]/body[1]/div[2]
+	Path string
+}
+
+func (d Diagnostic) String() string {
+	location := ""
+	if d.Position.Line > 0 {
+		location = fmt.Sprintf("%d:%d: ", d.Position.Line, d.Position.Column)
+	}
+	return fmt.Sprintf("%s%s: %s [%s] (%s)", location, d.Severity, d.Message, d.Code, d.Path)
+}
+
+const (
+	// RawHTMLDiagnosticCode marks a subtree emitted as raw HTML
+	RawHTMLDiagnosticCode = "raw-html"
+	// PreChildrenDiagnosticCode marks elements dropped from a pre block
+	PreChildrenDiagnosticCode = "pre-children-dropped"
+	// HeadDiagnosticCode marks head content discarded without KeepHead
+	HeadDiagnosticCode = "head-discarded"
+	// ConditionalDiagnosticCode marks a rewritten conditional comment
+	ConditionalDiagnosticCode = "conditional-comment"
+	// ClassAttributeDiagnosticCode marks class names not valid as shorthand
+	ClassAttributeDiagnosticCode = "class-attribute"
+	// IdAttributeDiagnosticCode marks an id not valid as shorthand
+	IdAttributeDiagnosticCode = "id-attribute"
+	// TagInterpolationDiagnosticCode marks a tag name written as #{'...'}
+	TagInterpolationDiagnosticCode = "tag-interpolation"
+	// AttributeInterpolationDiagnosticCode marks an attribute value with
+	// interpolation syntax, which Pug keeps literally
+	AttributeInterpolationDiagnosticCode = "attribute-interpolation"
+	// AssetDiagnosticCode marks an asset that could not be extracted
+	AssetDiagnosticCode = "asset-not-extracted"
+	// SelectDiagnosticCode marks a Select selector matching no element
+	SelectDiagnosticCode = "select-no-match"
+	// ParseDiagnosticCode marks an error reported by the parser
+	ParseDiagnosticCode = "parse-error"
+)
+
 type Html2JadeConvertorConvertDocumentCallback func(err error, jadeOutput string)
 
+type Html2JadeConvertorConvertDocumentWithDiagnosticsCallback func(err error, jadeOutput string, diagnostics []Diagnostic)
+type Html2JadeConvertorConvertDocumentWithSourceMapCallback func(err error, jadeOutput string, sourceMap *SourceMap, diagnostics []Diagnostic)
+
 type ParserCallback func(err []error, window Window)
 
 var DoIndent = true
@@ -56,4 +406,7 @@ type TextOptions struct {
 	Trim            bool
 	Wrap            bool
 	EscapeBackslash bool
+	// EscapeInterpolation escapes the Pug interpolation sequences in text
+	// written without EncodeEntityRef, e.g. in script. blocks
+	EscapeInterpolation bool
 }
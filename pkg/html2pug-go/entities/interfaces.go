@@ -8,6 +8,11 @@ import (
 
 type IHtml2JadeConvertor interface {
 	ConvertHTML(html string, callback Html2JadeConvertorConvertDocumentCallback)
+	ConvertHTMLWithDiagnostics(html string, callback Html2JadeConvertorConvertDocumentWithDiagnosticsCallback)
+	ConvertHTMLWithSourceMap(html string, options SourceMapOptions, callback Html2JadeConvertorConvertDocumentWithSourceMapCallback)
+	ConvertNode(node *html.Node, callback Html2JadeConvertorConvertDocumentWithDiagnosticsCallback)
+	ConvertNodes(nodes []*html.Node, callback Html2JadeConvertorConvertDocumentWithDiagnosticsCallback)
+	ConvertDocument(document *Document, callback Html2JadeConvertorConvertDocumentWithDiagnosticsCallback)
 }
 
 type IStringWriter interface {
@@ -27,6 +32,14 @@ type IStreamOutput interface {
 type IStringOutput interface {
 	IStringWriter
 }
+
+// ISourceMapper is implemented by outputs that record which HTML source
+// position produced each written line.
+type ISourceMapper interface {
+	SetSource(position *Position)
+	GetSource() *Position
+	GetMappings() []SourceMapping
+}
 type IConvertor interface {
 	Document(document *Document, output *IStringWriter)
 	Element(node *html.Node, output *IStringWriter, doNotEncode bool)
@@ -38,6 +51,12 @@ type IConvertor interface {
 	Style(*html.Node, *IStringWriter, string, string)
 }
 
+// IDiagnosticConvertor is implemented by convertors that report the lossy or
+// risky conversions of a document.
+type IDiagnosticConvertor interface {
+	DocumentWithDiagnostics(document *Document, output *IStringWriter) []Diagnostic
+}
+
 type IParser interface {
 	Parse(html io.Reader, callback ParserCallback)
 }
@@ -53,3 +72,13 @@ type IWriter interface {
 	WriteTextLine(*html.Node, string, *IStringWriter, TextOptions)
 	BreakLine(string) []string
 }
+
+type ITracer interface {
+	Trace(event TraceEvent)
+}
+
+type IAssetExtractor interface {
+	Extract(content []byte, ext string) (fileName string, err error)
+	Include(fileName string) string
+	URL(fileName string) string
+}
@@ -1,12 +1,20 @@
 package pkg
 
 import (
+	"unicode/utf16"
+
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
 )
 
 type Output struct {
 	Options *entities.Html2JadeConvertorOptions
 	Indents string
+	// Source is the HTML position of the node being written
+	Source   *entities.Position
+	Mappings []entities.SourceMapping
+
+	line   int
+	column int
 }
 
 // NewOutput
@@ -27,26 +35,15 @@ func (o *Output) Final() (output string) {
 // Write implements entities.IOutput.
 func (o *Output) Write(data string, indent bool) {
 	panic("unimplemented 2")
-
-	if indent {
-		print(o.Indents)
-	}
-	print(data)
 }
 
 // WriteLine implements entities.IOutput.
 func (o *Output) WriteLine(data string, indent bool) {
 	panic("unimplemented 3")
-
-	if indent {
-		print(o.Indents)
-	}
-	println(data)
 }
 
 func (o *Output) GetIndents() (indents string) {
 	panic("unimplemented 4")
-	return o.Indents
 }
 
 // Enter
@@ -85,3 +82,48 @@ func (o *Output) Leave() {
 		}
 	}
 }
+
+// SetSource implements entities.ISourceMapper.
+func (o *Output) SetSource(position *entities.Position) {
+	o.Source = position
+}
+
+// GetSource implements entities.ISourceMapper.
+func (o *Output) GetSource() *entities.Position {
+	return o.Source
+}
+
+// GetMappings implements entities.ISourceMapper.
+func (o *Output) GetMappings() []entities.SourceMapping {
+	return o.Mappings
+}
+
+// track maps every line fragment starts on the current source and moves the
+// generated position past it. Columns count UTF-16 code units, as source
+// maps do.
+func (o *Output) track(fragment string) {
+	for _, r := range fragment {
+		if r == '\n' {
+			o.line++
+			o.column = 0
+			continue
+		}
+		if o.Source != nil && !o.mapped() {
+			o.Mappings = append(o.Mappings, entities.SourceMapping{
+				GeneratedLine:   o.line,
+				GeneratedColumn: o.column,
+				Source:          *o.Source,
+			})
+		}
+		o.column += utf16.RuneLen(r)
+	}
+}
+
+// mapped reports whether the current line already maps to the current source.
+func (o *Output) mapped() bool {
+	if len(o.Mappings) == 0 {
+		return false
+	}
+	last := o.Mappings[len(o.Mappings)-1]
+	return last.GeneratedLine == o.line && last.Source == *o.Source
+}
@@ -1,12 +1,18 @@
 package pkg
 
 import (
+	"bytes"
 	"io"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
 	html "golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
 )
 
+var utf8BOM = []byte("\ufeff")
+
 type Parser struct {
 	Options *entities.Html2JadeConvertorOptions
 }
@@ -23,7 +29,31 @@ func (p *Parser) Parse(htmlContentReader io.Reader, callback entities.ParserCall
 	window := entities.Window{}
 
 	var errors []error
-	doc, err := html.Parse(htmlContentReader)
+	var parseOptions []html.ParseOption
+	if p.Options.Noscript == entities.DisableScriptingNoscriptMode {
+		parseOptions = append(parseOptions, html.ParseOptionEnableScripting(false))
+	}
+
+	if p.Options.DetectCharset {
+		decoded, err := charset.NewReader(htmlContentReader, p.Options.ContentType)
+		if err != nil {
+			errors = append(errors, err)
+			callback(errors, window)
+			return
+		}
+		htmlContentReader = decoded
+	}
+
+	content, err := io.ReadAll(htmlContentReader)
+	if err != nil {
+		errors = append(errors, err)
+		callback(errors, window)
+		return
+	}
+	// charset.NewReader keeps a UTF-8 BOM, which would become a text node
+	content = bytes.TrimPrefix(content, utf8BOM)
+
+	doc, err := html.ParseWithOptions(bytes.NewReader(content), parseOptions...)
 	if err != nil {
 		errors = append(errors, err)
 	}
@@ -31,6 +61,89 @@ func (p *Parser) Parse(htmlContentReader io.Reader, callback entities.ParserCall
 	window.Document = &entities.Document{
 		Root: doc,
 	}
+	// Re-tokenizing is only worth it when positions are reported
+	window.Document.SetLocator(func() map[*html.Node]entities.Position {
+		return p.positions(content, doc)
+	})
 
 	callback(errors, window)
 }
+
+// sourceToken is a start tag or comment found by the tokenizer.
+type sourceToken struct {
+	nodeType html.NodeType
+	name     string
+	position entities.Position
+}
+
+// positionLookahead bounds how far ahead of the expected token a node is
+// matched, so elements the parser implied or moved don't derail the rest.
+const positionLookahead = 8
+
+// positions matches the element and comment nodes of doc, in document order,
+// with the start tags and comments produced by tokenizing content. The HTML5
+// parser does not report source locations, so nodes it implies (html, head,
+// body, tbody) or reorders (foster parenting, reconstructed formatting
+// elements) may have no position.
+func (p *Parser) positions(content []byte, doc *html.Node) map[*html.Node]entities.Position {
+	var tokens []sourceToken
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+	position := entities.Position{Line: 1, Column: 1}
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		raw := tokenizer.Raw()
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tokens = append(tokens, sourceToken{nodeType: html.ElementNode, name: string(name), position: position})
+			if string(name) == "noscript" && p.Options.Noscript == entities.DisableScriptingNoscriptMode {
+				tokenizer.NextIsNotRawText()
+			}
+		case html.CommentToken:
+			tokens = append(tokens, sourceToken{nodeType: html.CommentNode, position: position})
+		}
+
+		position = advancePosition(position, raw)
+	}
+
+	positions := map[*html.Node]entities.Position{}
+	cursor := 0
+
+	var match func(node *html.Node)
+	match = func(node *html.Node) {
+		if node.Type == html.ElementNode || node.Type == html.CommentNode {
+			for i := cursor; i < len(tokens) && i < cursor+positionLookahead; i++ {
+				if tokens[i].nodeType == node.Type && (node.Type == html.CommentNode || strings.EqualFold(tokens[i].name, node.Data)) {
+					positions[node] = tokens[i].position
+					cursor = i + 1
+					break
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			match(child)
+		}
+	}
+	if doc != nil {
+		match(doc)
+	}
+
+	return positions
+}
+
+// advancePosition moves position past text.
+func advancePosition(position entities.Position, text []byte) entities.Position {
+	position.Offset += len(text)
+	if i := bytes.LastIndexByte(text, '\n'); i >= 0 {
+		position.Line += bytes.Count(text, []byte{'\n'})
+		position.Column = 1 + utf8.RuneCount(text[i+1:])
+	} else {
+		position.Column += utf8.RuneCount(text)
+	}
+	return position
+}
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	html "golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadTransformRules reads a YAML rules file, e.g.
+//
+//	rules:
+//	  - select: script[src*=analytics]
+//	    remove: true
+//	  - select: "[ng-bind]"
+//	    rename-attr: {ng-bind: v-text}
+//	  - select: "*"
+//	    remove-class: [js-*]
+func LoadTransformRules(filePath string) (rules []entities.TransformRule, err error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if rules, err = ParseTransformRules(content); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return
+}
+
+// ParseTransformRules parses YAML rules, rejecting unknown actions and
+// invalid selectors.
+func ParseTransformRules(content []byte) ([]entities.TransformRule, error) {
+	var ruleFile entities.TransformRuleFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	// An empty file has no rules
+	if err := decoder.Decode(&ruleFile); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// Compile the transforms to report invalid rules now rather than when
+	// converting
+	if _, err := RuleTransforms(ruleFile.Rules); err != nil {
+		return nil, err
+	}
+	return ruleFile.Rules, nil
+}
+
+// compiledRule is a TransformRule with its name patterns compiled.
+type compiledRule struct {
+	entities.TransformRule
+	removeAttr  []*util.Glob
+	removeClass []*util.Glob
+}
+
+// RuleTransforms compiles rules into one transform each, so every rule sees
+// the document as left by the previous ones.
+func RuleTransforms(rules []entities.TransformRule) (transforms []entities.Transform, err error) {
+	for i, rule := range rules {
+		if rule.Select == "" {
+			return nil, fmt.Errorf("rule %d: select is required", i+1)
+		}
+		selector, err := util.CompileSelector(rule.Select)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		compiled := compiledRule{TransformRule: rule}
+		if compiled.removeAttr, err = compileGlobs(rule.RemoveAttr); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if compiled.removeClass, err = compileGlobs(rule.RemoveClass); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		transforms = append(transforms, entities.Transform{
+			Enter: func(node *html.Node) {
+				if selector.Match(node) {
+					applyRule(compiled, node)
+				}
+			},
+		})
+	}
+	return
+}
+
+func compileGlobs(patterns []string) (globs []*util.Glob, err error) {
+	for _, pattern := range patterns {
+		glob, err := util.CompileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+	}
+	return
+}
+
+// applyRule changes the attributes and classes before the node itself, so
+// removing or unwrapping always comes last.
+func applyRule(rule compiledRule, node *html.Node) {
+	// Sorted so that renames do not depend on map order
+	renames := make([]string, 0, len(rule.RenameAttr))
+	for name := range rule.RenameAttr {
+		renames = append(renames, name)
+	}
+	sort.Strings(renames)
+	for _, name := range renames {
+		target := rule.RenameAttr[name]
+		if target == name || !util.HasAttr(node, name) {
+			continue
+		}
+		// The renamed attribute replaces any it is renamed to
+		attrs := make([]html.Attribute, 0, len(node.Attr))
+		for _, attr := range node.Attr {
+			if attr.Key == target {
+				continue
+			}
+			if attr.Key == name {
+				attr.Key = target
+			}
+			attrs = append(attrs, attr)
+		}
+		node.Attr = attrs
+	}
+
+	names := make([]string, 0, len(rule.SetAttr))
+	for name := range rule.SetAttr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		util.SetAttr(node, name, rule.SetAttr[name])
+	}
+
+	for _, attr := range append([]html.Attribute{}, node.Attr...) {
+		if matchesAny(rule.removeAttr, attr.Key) {
+			util.RemoveAttr(node, attr.Key)
+		}
+	}
+
+	if len(rule.AddClass) > 0 || len(rule.RemoveClass) > 0 {
+		var classNames []string
+		for _, className := range strings.Fields(util.GetAttr(node, "class")) {
+			if !matchesAny(rule.removeClass, className) {
+				classNames = append(classNames, className)
+			}
+		}
+		for _, className := range rule.AddClass {
+			if !containsClass(classNames, className) {
+				classNames = append(classNames, className)
+			}
+		}
+		if len(classNames) > 0 {
+			util.SetAttr(node, "class", strings.Join(classNames, " "))
+		} else {
+			util.RemoveAttr(node, "class")
+		}
+	}
+
+	if rule.Rename != "" {
+		util.RenameNode(node, rule.Rename)
+	}
+
+	if rule.Remove {
+		util.RemoveNode(node)
+	} else if rule.Unwrap {
+		util.UnwrapNode(node)
+	}
+}
+
+func matchesAny(globs []*util.Glob, name string) bool {
+	for _, glob := range globs {
+		if glob.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsClass(classNames []string, className string) bool {
+	for _, name := range classNames {
+		if name == className {
+			return true
+		}
+	}
+	return false
+}
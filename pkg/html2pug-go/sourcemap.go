@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+)
+
+const base64VLQDigits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// NewSourceMap builds a Source Map v3 document from the mappings recorded by
+// an entities.ISourceMapper output. htmlContent is only embedded when
+// options.SourcesContent is set.
+func NewSourceMap(mappings []entities.SourceMapping, options entities.SourceMapOptions, htmlContent string) (sourceMap *entities.SourceMap) {
+	sourceMap = &entities.SourceMap{
+		Version: 3,
+		File:    options.File,
+		Sources: []string{options.Source},
+		Names:   []string{},
+	}
+	if options.SourcesContent {
+		sourceMap.SourcesContent = []string{htmlContent}
+	}
+
+	sorted := append([]entities.SourceMapping{}, mappings...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].GeneratedLine != sorted[j].GeneratedLine {
+			return sorted[i].GeneratedLine < sorted[j].GeneratedLine
+		}
+		return sorted[i].GeneratedColumn < sorted[j].GeneratedColumn
+	})
+
+	// Every field is relative to the previous segment, the generated column
+	// only within its line
+	var sb strings.Builder
+	line, previousColumn, previousSourceLine, previousSourceColumn := 0, 0, 0, 0
+	for i, mapping := range sorted {
+		if i > 0 && mapping.GeneratedLine == line {
+			sb.WriteByte(',')
+		}
+		for ; line < mapping.GeneratedLine; line++ {
+			sb.WriteByte(';')
+			previousColumn = 0
+		}
+
+		sourceLine := mapping.Source.Line - 1
+		sourceColumn := sourceColumn(mapping.Source, htmlContent)
+		writeVLQ(&sb, mapping.GeneratedColumn-previousColumn)
+		writeVLQ(&sb, 0)
+		writeVLQ(&sb, sourceLine-previousSourceLine)
+		writeVLQ(&sb, sourceColumn-previousSourceColumn)
+
+		previousColumn = mapping.GeneratedColumn
+		previousSourceLine, previousSourceColumn = sourceLine, sourceColumn
+	}
+	sourceMap.Mappings = sb.String()
+
+	return
+}
+
+// sourceColumn returns the 0-based column of position in UTF-16 code units,
+// as source maps count them, where position columns count runes. It falls
+// back to the rune column when htmlContent is not the parsed content, e.g.
+// after charset decoding.
+func sourceColumn(position entities.Position, htmlContent string) int {
+	column := position.Column - 1
+	if position.Offset > len(htmlContent) {
+		return column
+	}
+	lineStart := strings.LastIndexByte(htmlContent[:position.Offset], '\n') + 1
+	prefix := htmlContent[lineStart:position.Offset]
+	if utf8.RuneCountInString(prefix) != column {
+		return column
+	}
+	units := 0
+	for _, r := range prefix {
+		units += utf16.RuneLen(r)
+	}
+	return units
+}
+
+// writeVLQ appends value as a base64 VLQ, the sign in the lowest bit.
+func writeVLQ(sb *strings.Builder, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 0x1f
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 0x20
+		}
+		sb.WriteByte(base64VLQDigits[digit])
+		if vlq == 0 {
+			break
+		}
+	}
+}
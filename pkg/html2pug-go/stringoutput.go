@@ -9,6 +9,10 @@ import (
 type StringOutput struct {
 	Output
 	Fragments []string
+
+	// sourceMap records the mappings of the written lines, see
+	// NewSourceMapOutput
+	sourceMap bool
 }
 
 func NewStringOutput(options *entities.Html2JadeConvertorOptions) (stringOutput entities.IStringOutput) {
@@ -22,6 +26,22 @@ func NewStringOutput(options *entities.Html2JadeConvertorOptions) (stringOutput
 
 	return
 
+}
+
+// NewSourceMapOutput returns a string output that also records the source
+// mappings of the written lines, see entities.ISourceMapper.
+func NewSourceMapOutput(options *entities.Html2JadeConvertorOptions) (stringOutput entities.IStringOutput) {
+
+	stringOutput = &StringOutput{
+		Output: Output{
+			Options: options,
+		},
+		Fragments: make([]string, 0),
+		sourceMap: true,
+	}
+
+	return
+
 }
 func (so *StringOutput) GetIndents() (indents string) {
 	return so.Indents
@@ -29,10 +49,12 @@ func (so *StringOutput) GetIndents() (indents string) {
 func (so *StringOutput) Write(data string, indent bool) {
 
 	if indent {
-		so.Fragments = append(so.Fragments, so.Indents+data)
-	} else {
-		so.Fragments = append(so.Fragments, data)
+		data = so.Indents + data
+	}
+	if so.sourceMap {
+		so.track(data)
 	}
+	so.Fragments = append(so.Fragments, data)
 }
 func (so *StringOutput) WriteLine(data string, indent bool) {
 
@@ -41,14 +63,37 @@ func (so *StringOutput) WriteLine(data string, indent bool) {
 	}
 
 	if indent {
-		so.Fragments = append(so.Fragments, so.Indents+data+"\n")
-	} else {
-		so.Fragments = append(so.Fragments, data+"\n")
+		data = so.Indents + data
 	}
+	if so.sourceMap {
+		so.track(data + "\n")
+	}
+	so.Fragments = append(so.Fragments, data+"\n")
 }
 
 func (so *StringOutput) Final() (output string) {
 	output = strings.Join(so.Fragments, "")
 	so.Fragments = []string{}
+
+	outputOptions := so.Options.OutputOptions
+	if outputOptions == nil {
+		return
+	}
+
+	if outputOptions.FinalNewline && output != "" && !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+	if outputOptions.LineEnding != "" && outputOptions.LineEnding != entities.LFLineEnding {
+		output = strings.ReplaceAll(output, "\n", string(outputOptions.LineEnding))
+	}
+	if outputOptions.BOM {
+		output = "\ufeff" + output
+		// The BOM moves the first line one UTF-16 unit to the right
+		for i := range so.Mappings {
+			if so.Mappings[i].GeneratedLine == 0 {
+				so.Mappings[i].GeneratedColumn++
+			}
+		}
+	}
 	return
 }
@@ -0,0 +1,239 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	html "golang.org/x/net/html"
+)
+
+// defaultPredicateTagHandlers returns the built-in handlers of c for the
+// elements they match, whatever their tag name.
+func (c *Convertor) defaultPredicateTagHandlers() []entities.PredicateTagHandler {
+	return []entities.PredicateTagHandler{
+		{Match: func(node *html.Node) bool { return c.Unrepresentable(node) != "" }, Handle: c.RawHTMLTag},
+	}
+}
+
+// defaultTagHandlers returns the built-in handlers of c by tag name, chained
+// in order. Other elements are converted by GenericTag.
+func (c *Convertor) defaultTagHandlers() map[string][]entities.TagHandler {
+	return map[string][]entities.TagHandler{
+		"html":        {c.BodylessTag},
+		"body":        {c.BodylessTag},
+		"head":        {c.HeadTag},
+		"script":      {c.AssetTag, c.ScriptTag},
+		"style":       {c.AssetTag, c.StyleTag},
+		"svg":         {c.AssetTag},
+		"conditional": {c.ConditionalTag},
+		"pre":         {c.PreTag},
+	}
+}
+
+// Handle converts the element of ctx with its handler. The handlers matching
+// the element are chained in order: Options.PredicateTagHandlers, then
+// Options.TagHandlers, then the built-in predicate and tag handlers and
+// finally GenericTag, each handler's Next running the following one.
+func (c *Convertor) Handle(ctx *entities.TagContext) {
+	var handlers []entities.TagHandler
+	var custom []bool
+
+	for _, predicateHandler := range c.Options.PredicateTagHandlers {
+		if predicateHandler.Handle != nil && predicateHandler.Match != nil && predicateHandler.Match(ctx.Node) {
+			handlers = append(handlers, predicateHandler.Handle)
+			custom = append(custom, true)
+		}
+	}
+	if handler := c.Options.TagHandlers[ctx.TagName]; handler != nil {
+		handlers = append(handlers, handler)
+		custom = append(custom, true)
+	}
+	for _, predicateHandler := range c.predicateTagHandlers {
+		if predicateHandler.Match(ctx.Node) {
+			handlers = append(handlers, predicateHandler.Handle)
+			custom = append(custom, false)
+		}
+	}
+	for _, handler := range c.tagHandlers[ctx.TagName] {
+		handlers = append(handlers, handler)
+		custom = append(custom, false)
+	}
+	handlers = append(handlers, c.GenericTag)
+	custom = append(custom, false)
+
+	var run func(i int)
+	run = func(i int) {
+		// Each handler gets its own context so its Next stays put
+		handlerCtx := *ctx
+		handlerCtx.Next = func() {
+			if i+1 < len(handlers) {
+				run(i + 1)
+			}
+		}
+		if custom[i] {
+			c.Trace(ctx.Source, entities.TraceEvent{Decision: entities.CustomHandlerTraceDecision, Shorthand: ctx.Shorthand, Detail: ctx.TagName})
+		}
+		handlers[i](&handlerCtx)
+	}
+	run(0)
+}
+
+func (c *Convertor) traceTag(ctx *entities.TagContext, decision entities.TraceDecision) {
+	// Trace events refer to the parsed node, not the rewritten copies
+	c.Trace(ctx.Source, entities.TraceEvent{Decision: decision, Shorthand: ctx.Shorthand})
+}
+
+// RawHTMLTag writes the elements Pug cannot represent as raw HTML.
+func (c *Convertor) RawHTMLTag(ctx *entities.TagContext) {
+	reason := c.Unrepresentable(ctx.Node)
+	c.Trace(ctx.Source, entities.TraceEvent{Decision: entities.RawHTMLTraceDecision, Detail: reason})
+	c.RawHTML(ctx.Node, ctx.Output, reason)
+}
+
+// BodylessTag skips the html and body elements under Options.Bodyless,
+// converting only their children.
+func (c *Convertor) BodylessTag(ctx *entities.TagContext) {
+	if !c.Options.Bodyless {
+		ctx.Next()
+		return
+	}
+	c.traceTag(ctx, entities.BodylessSkipTraceDecision)
+	c.Children(ctx.Node, ctx.Output, false)
+}
+
+// HeadTag discards the head element unless Options.KeepHead is set.
+func (c *Convertor) HeadTag(ctx *entities.TagContext) {
+	if c.Options.KeepHead {
+		ctx.Next()
+		return
+	}
+	c.traceTag(ctx, entities.HeadSkipTraceDecision)
+	for child := ctx.Node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			c.Diagnose(ctx.Source, entities.InfoDiagnosticSeverity, entities.HeadDiagnosticCode,
+				"head content discarded, set KeepHead to keep it")
+			break
+		}
+	}
+}
+
+// AssetTag extracts an inline script, style or svg to a file, see Assets.
+func (c *Convertor) AssetTag(ctx *entities.TagContext) {
+	if !c.Assets(ctx.Source, ctx.Output) {
+		ctx.Next()
+		return
+	}
+	c.traceTag(ctx, entities.AssetTraceDecision)
+}
+
+// ExternalTag writes a script or style with a src attribute.
+func (c *Convertor) ExternalTag(ctx *entities.TagContext) {
+	c.traceTag(ctx, entities.ExternalTraceDecision)
+	(*ctx.Output).WriteLine(ctx.TagHead+ctx.TagAttr, true)
+	(*c.Writer).WriteTextContent(ctx.Node, ctx.Output, entities.TextOptions{
+		Pipe:                false,
+		Wrap:                false,
+		EscapeInterpolation: true,
+	})
+}
+
+// ScriptTag is the built-in script handler.
+func (c *Convertor) ScriptTag(ctx *entities.TagContext) {
+	c.DiagnoseTagHead(ctx.Source)
+	if util.HasAttr(ctx.Node, "src") {
+		c.ExternalTag(ctx)
+		return
+	}
+	c.traceTag(ctx, entities.ScriptTraceDecision)
+	c.Script(ctx.Node, ctx.Output, ctx.TagHead, ctx.TagAttr)
+}
+
+// StyleTag is the built-in style handler.
+func (c *Convertor) StyleTag(ctx *entities.TagContext) {
+	c.DiagnoseTagHead(ctx.Source)
+	if util.HasAttr(ctx.Node, "src") {
+		c.ExternalTag(ctx)
+		return
+	}
+	c.traceTag(ctx, entities.StyleTraceDecision)
+	c.Style(ctx.Node, ctx.Output, ctx.TagHead, ctx.TagAttr)
+}
+
+// ConditionalTag writes the conditional elements built by Conditional.
+func (c *Convertor) ConditionalTag(ctx *entities.TagContext) {
+	cond := util.GetAttr(ctx.Node, "condition")
+	(*ctx.Output).WriteLine("//"+cond, true)
+	c.Children(ctx.Node, ctx.Output, true)
+}
+
+// PreTag is the built-in pre handler.
+func (c *Convertor) PreTag(ctx *entities.TagContext) {
+	c.DiagnoseTagHead(ctx.Source)
+	c.traceTag(ctx, entities.PreTraceDecision)
+	output := ctx.Output
+	(*output).WriteLine(ctx.TagHead+ctx.TagAttr+".", true)
+	(*output).Enter()
+	firstLine := true
+	for child := ctx.Node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			c.Diagnose(child, entities.WarningDiagnosticSeverity, entities.PreChildrenDiagnosticCode,
+				fmt.Sprintf("<%s> inside <pre> dropped, only its text is kept", child.Data))
+		}
+		if child.Type == html.TextNode || child.Type == html.RawNode {
+			data := child.Data
+			if strings.TrimSpace(data) != "" {
+				if firstLine {
+					data = strings.TrimLeft(data, "\r\n")
+					data = "\\n" + data
+					firstLine = false
+				}
+				data = util.EscapeInterpolation(data)
+				data = strings.ReplaceAll(data, "\t", "\\t")
+				data = strings.ReplaceAll(data, "\r\n", "\n"+(*output).GetIndents())
+				data = strings.ReplaceAll(data, "\r", "\n"+(*output).GetIndents())
+				data = strings.ReplaceAll(data, "\n", "\n"+(*output).GetIndents())
+				(*output).Write(data, true)
+			}
+		}
+	}
+	(*output).WriteLine("", true)
+	(*output).Leave()
+}
+
+// GenericTag converts the elements without a handler of their own.
+func (c *Convertor) GenericTag(ctx *entities.TagContext) {
+	node, output := ctx.Node, ctx.Output
+	tagHead, tagAttr, tagText := ctx.TagHead, ctx.TagAttr, ctx.TagText
+	c.DiagnoseTagHead(ctx.Source)
+
+	if isRawContent(node) {
+		c.traceTag(ctx, entities.RawContentTraceDecision)
+		(*output).WriteLine(tagHead+tagAttr+".", true)
+		(*c.Writer).WriteTextContent(node, output, entities.TextOptions{
+			Pipe:                false,
+			Wrap:                false,
+			EscapeInterpolation: true,
+		})
+	} else if blockText := c.BlockText(node); tagText == nil && blockText != nil {
+		c.traceTag(ctx, entities.BlockTextTraceDecision)
+		(*output).WriteLine(tagHead+tagAttr+".", true)
+		(*output).Enter()
+		for _, line := range blockText {
+			(*output).WriteLine(line, true)
+		}
+		(*output).Leave()
+	} else if tagText != nil {
+		c.traceTag(ctx, entities.InlineTextTraceDecision)
+		if ctx.DoNotEncode {
+			(*output).WriteLine(tagHead+tagAttr+" "+*tagText, true)
+		} else {
+			(*output).WriteLine(tagHead+tagAttr+" "+util.EscapeInterpolation(html.EscapeString(*tagText)), true)
+		}
+	} else {
+		c.traceTag(ctx, entities.ChildrenTraceDecision)
+		(*output).WriteLine(tagHead+tagAttr, true)
+		c.Children(node, output, true)
+	}
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	"github.com/sirupsen/logrus"
+	html "golang.org/x/net/html"
+)
+
+// LogTracer writes trace events to a logrus logger at debug level.
+type LogTracer struct {
+	Logger logrus.FieldLogger
+}
+
+// NewLogTracer
+func NewLogTracer(logger logrus.FieldLogger) (tracer entities.ITracer) {
+	tracer = &LogTracer{
+		Logger: logger,
+	}
+	return
+}
+
+// Trace implements entities.ITracer.
+func (lt *LogTracer) Trace(event entities.TraceEvent) {
+	fields := logrus.Fields{
+		"node": event.Node,
+		"path": event.Path,
+	}
+	if event.Position.Line > 0 {
+		fields["line"] = event.Position.Line
+		fields["column"] = event.Position.Column
+	}
+	if len(event.Shorthand) > 0 {
+		fields["shorthand"] = event.Shorthand
+	}
+	if event.Text != "" {
+		fields["text"] = event.Text
+	}
+	if event.Detail != "" {
+		fields["detail"] = event.Detail
+	}
+	lt.Logger.WithFields(fields).Debug(string(event.Decision))
+}
+
+// JSONTracer writes trace events as JSON, one object per line.
+type JSONTracer struct {
+	Encoder *json.Encoder
+
+	mu sync.Mutex
+}
+
+// NewJSONTracer
+func NewJSONTracer(writer io.Writer) (tracer entities.ITracer) {
+	tracer = &JSONTracer{
+		Encoder: json.NewEncoder(writer),
+	}
+	return
+}
+
+// Trace implements entities.ITracer.
+func (jt *JSONTracer) Trace(event entities.TraceEvent) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	// Tracing must not fail the conversion, so write errors are dropped
+	_ = jt.Encoder.Encode(event)
+}
+
+// traceNode fills in the node details of event and passes it to the tracer
+// in options, if any. document may be nil.
+func traceNode(options *entities.Html2JadeConvertorOptions, document *entities.Document, node *html.Node, event entities.TraceEvent) {
+	if options.Tracer == nil {
+		return
+	}
+
+	switch node.Type {
+	case html.TextNode, html.RawNode:
+		event.Node = "#text"
+	case html.CommentNode:
+		event.Node = "#comment"
+	default:
+		event.Node = node.Data
+	}
+	event.Path = util.NodePath(node)
+	if document != nil {
+		event.Position, _ = document.Position(node)
+	}
+
+	(*options.Tracer).Trace(event)
+}
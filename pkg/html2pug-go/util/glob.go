@@ -0,0 +1,88 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Glob is a compiled name pattern, see CompileGlob.
+type Glob struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// CompileGlob compiles a pattern matching attribute or class names. '*'
+// matches any run of characters, '?' any one character, '[...]' a character
+// class (negated with '!' or '^') and '\' escapes the next character. Unlike
+// path.Match, '/' is an ordinary character, as in Tailwind's w-1/2.
+func CompileGlob(pattern string) (*Glob, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, fmt.Errorf("glob %q: trailing \\", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end, err := globClass(pattern, i, &expr)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("glob %q: %w", pattern, err)
+	}
+	return &Glob{source: pattern, re: re}, nil
+}
+
+// globClass writes the character class of pattern starting at the '[' at
+// start, returning the index of its closing ']'.
+func globClass(pattern string, start int, expr *strings.Builder) (end int, err error) {
+	i := start + 1
+	expr.WriteString("[")
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		expr.WriteString("^")
+		i++
+	}
+	first := i
+	for ; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == ']' && i > first:
+			expr.WriteString("]")
+			return i, nil
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '[' || c == ']' || c == '\\' || c == '^':
+			expr.WriteString(`\` + string(c))
+		default:
+			expr.WriteByte(c)
+		}
+	}
+	return 0, fmt.Errorf("glob %q: unterminated character class", pattern)
+}
+
+// String returns the pattern source.
+func (g *Glob) String() string {
+	return g.source
+}
+
+// Match reports whether name matches the whole pattern.
+func (g *Glob) Match(name string) bool {
+	return g.re.MatchString(name)
+}
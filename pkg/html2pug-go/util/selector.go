@@ -0,0 +1,540 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	html "golang.org/x/net/html"
+)
+
+// Selector is a compiled CSS selector list, e.g. `script[src*=analytics], .ad`.
+type Selector struct {
+	source       string
+	alternatives []complexSelector
+}
+
+// complexSelector is a chain of compound selectors joined by the
+// combinators ' ', '>', '+' and '~', matched from the right.
+type complexSelector struct {
+	compounds   []compoundSelector
+	combinators []byte
+}
+
+// compoundSelector is a sequence of simple selectors without combinators,
+// e.g. a.nav[href^="/"].
+type compoundSelector struct {
+	tagName    string
+	ids        []string
+	classNames []string
+	attrs      []attrSelector
+	pseudos    []pseudoSelector
+}
+
+// pseudoSelector is a structural pseudo-class. The nth ones match the
+// elements at index a*n+b for some n >= 0, the others use a and b as 0n+1.
+type pseudoSelector struct {
+	name string
+	a, b int
+	not  *Selector
+}
+
+// attrSelector is an [attr], [attr=value] or [attr op value] selector.
+type attrSelector struct {
+	name            string
+	operator        string
+	value           string
+	caseInsensitive bool
+}
+
+// CompileSelector parses a comma separated CSS selector list. It supports
+// type, universal, #id, .class and attribute selectors, the descendant, >,
+// + and ~ combinators, :not() and the structural pseudo-classes :root,
+// :empty, :first-child, :last-child, :only-child, :nth-child(),
+// :nth-last-child(), :first-of-type, :last-of-type, :only-of-type,
+// :nth-of-type() and :nth-last-of-type().
+func CompileSelector(selector string) (*Selector, error) {
+	p := &selectorParser{input: selector}
+	compiled, err := p.selectorList()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return compiled, nil
+}
+
+// String returns the selector source.
+func (s *Selector) String() string {
+	return s.source
+}
+
+// Match reports whether node matches any selector of the list.
+func (s *Selector) Match(node *html.Node) bool {
+	if node == nil || node.Type != html.ElementNode {
+		return false
+	}
+	for _, complex := range s.alternatives {
+		if complex.matchAt(node, len(complex.compounds)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs complexSelector) matchAt(node *html.Node, i int) bool {
+	if !cs.compounds[i].match(node) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	switch cs.combinators[i-1] {
+	case ' ':
+		for ancestor := node.Parent; ancestor != nil && ancestor.Type == html.ElementNode; ancestor = ancestor.Parent {
+			if cs.matchAt(ancestor, i-1) {
+				return true
+			}
+		}
+	case '>':
+		parent := node.Parent
+		return parent != nil && parent.Type == html.ElementNode && cs.matchAt(parent, i-1)
+	case '+':
+		sibling := previousElement(node)
+		return sibling != nil && cs.matchAt(sibling, i-1)
+	case '~':
+		for sibling := previousElement(node); sibling != nil; sibling = previousElement(sibling) {
+			if cs.matchAt(sibling, i-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (cs compoundSelector) match(node *html.Node) bool {
+	if cs.tagName != "" && cs.tagName != "*" && !strings.EqualFold(cs.tagName, node.Data) {
+		return false
+	}
+	for _, id := range cs.ids {
+		if GetAttr(node, "id") != id {
+			return false
+		}
+	}
+	classNames := strings.Fields(GetAttr(node, "class"))
+	for _, className := range cs.classNames {
+		if !containsString(classNames, className) {
+			return false
+		}
+	}
+	for _, attr := range cs.attrs {
+		if !attr.match(node) {
+			return false
+		}
+	}
+	for _, pseudo := range cs.pseudos {
+		if !pseudo.match(node) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ps pseudoSelector) match(node *html.Node) bool {
+	switch ps.name {
+	case "not":
+		return !ps.not.Match(node)
+	case "root":
+		return node.Parent != nil && node.Parent.Type == html.DocumentNode
+	case "empty":
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode || child.Type == html.TextNode && child.Data != "" {
+				return false
+			}
+		}
+		return true
+	case "only-child":
+		return previousElement(node) == nil && nextElement(node) == nil
+	case "only-of-type":
+		return siblingIndex(node, previousElement, true) == 1 && siblingIndex(node, nextElement, true) == 1
+	case "first-child", "nth-child":
+		return ps.nth(siblingIndex(node, previousElement, false))
+	case "last-child", "nth-last-child":
+		return ps.nth(siblingIndex(node, nextElement, false))
+	case "first-of-type", "nth-of-type":
+		return ps.nth(siblingIndex(node, previousElement, true))
+	case "last-of-type", "nth-last-of-type":
+		return ps.nth(siblingIndex(node, nextElement, true))
+	}
+	return false
+}
+
+// nth reports whether the 1-based index is a*n+b for some n >= 0.
+func (ps pseudoSelector) nth(index int) bool {
+	if ps.a == 0 {
+		return index == ps.b
+	}
+	n := index - ps.b
+	return n%ps.a == 0 && n/ps.a >= 0
+}
+
+// siblingIndex counts node and the element siblings before or after it, as
+// given by step, optionally only those with the same tag name.
+func siblingIndex(node *html.Node, step func(*html.Node) *html.Node, ofType bool) int {
+	index := 1
+	for sibling := step(node); sibling != nil; sibling = step(sibling) {
+		if !ofType || strings.EqualFold(sibling.Data, node.Data) {
+			index++
+		}
+	}
+	return index
+}
+
+func previousElement(node *html.Node) *html.Node {
+	for sibling := node.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func nextElement(node *html.Node) *html.Node {
+	for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func (as attrSelector) match(node *html.Node) bool {
+	var value string
+	found := false
+	for _, attr := range node.Attr {
+		if strings.EqualFold(attr.Key, as.name) {
+			value, found = attr.Val, true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	expected := as.value
+	if as.caseInsensitive {
+		value, expected = strings.ToLower(value), strings.ToLower(expected)
+	}
+
+	switch as.operator {
+	case "":
+		return true
+	case "=":
+		return value == expected
+	case "~=":
+		return expected != "" && containsString(strings.Fields(value), expected)
+	case "|=":
+		return value == expected || strings.HasPrefix(value, expected+"-")
+	case "^=":
+		return expected != "" && strings.HasPrefix(value, expected)
+	case "$=":
+		return expected != "" && strings.HasSuffix(value, expected)
+	case "*=":
+		return expected != "" && strings.Contains(value, expected)
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type selectorParser struct {
+	input string
+	pos   int
+}
+
+func (p *selectorParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("selector %q at %d: %s", p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *selectorParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// selectorList parses complex selectors separated by commas, up to the end
+// of the input or a closing parenthesis.
+func (p *selectorParser) selectorList() (*Selector, error) {
+	start := p.pos
+	selector := &Selector{}
+	for {
+		p.skipSpace()
+		complex, err := p.complex()
+		if err != nil {
+			return nil, err
+		}
+		selector.alternatives = append(selector.alternatives, complex)
+
+		if p.peek() != ',' {
+			selector.source = strings.TrimSpace(p.input[start:p.pos])
+			return selector, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *selectorParser) complex() (complex complexSelector, err error) {
+	for {
+		compound, err := p.compound()
+		if err != nil {
+			return complex, err
+		}
+		complex.compounds = append(complex.compounds, compound)
+
+		combinator := byte(' ')
+		if !p.skipSpace() {
+			combinator = 0
+		}
+		if c := p.peek(); c == '>' || c == '+' || c == '~' {
+			combinator = c
+			p.pos++
+			p.skipSpace()
+		} else if p.done() || c == ',' || c == ')' {
+			return complex, nil
+		} else if combinator == 0 {
+			return complex, p.errorf("unexpected %q", c)
+		}
+		complex.combinators = append(complex.combinators, combinator)
+	}
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t\r\n\f", p.peek()) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *selectorParser) compound() (compound compoundSelector, err error) {
+	start := p.pos
+	if p.peek() == '*' {
+		p.pos++
+		compound.tagName = "*"
+	} else if isIdentStart(p.peek()) {
+		compound.tagName = p.ident()
+	}
+
+	for !p.done() {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id := p.ident()
+			if id == "" {
+				return compound, p.errorf("expected an id")
+			}
+			compound.ids = append(compound.ids, id)
+		case '.':
+			p.pos++
+			className := p.ident()
+			if className == "" {
+				return compound, p.errorf("expected a class name")
+			}
+			compound.classNames = append(compound.classNames, className)
+		case '[':
+			attr, err := p.attr()
+			if err != nil {
+				return compound, err
+			}
+			compound.attrs = append(compound.attrs, attr)
+		case ':':
+			pseudo, err := p.pseudo()
+			if err != nil {
+				return compound, err
+			}
+			compound.pseudos = append(compound.pseudos, pseudo)
+		default:
+			if p.pos == start {
+				return compound, p.errorf("expected a selector")
+			}
+			return compound, nil
+		}
+	}
+	if p.pos == start {
+		return compound, p.errorf("expected a selector")
+	}
+	return compound, nil
+}
+
+var nthRegExp = regexp.MustCompile(`^(?:([+-]?\d*)n\s*(?:([+-])\s*(\d+))?|([+-]?\d+))$`)
+
+func (p *selectorParser) pseudo() (pseudo pseudoSelector, err error) {
+	p.pos++ // :
+	pseudo.name = strings.ToLower(p.ident())
+
+	switch pseudo.name {
+	case "root", "empty", "only-child", "only-of-type":
+		return pseudo, nil
+	case "first-child", "last-child", "first-of-type", "last-of-type":
+		pseudo.b = 1
+		return pseudo, nil
+	case "not", "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+	default:
+		return pseudo, p.errorf("unsupported pseudo-class :%s", pseudo.name)
+	}
+
+	if p.peek() != '(' {
+		return pseudo, p.errorf("expected ( after :%s", pseudo.name)
+	}
+	p.pos++
+	p.skipSpace()
+
+	if pseudo.name == "not" {
+		if pseudo.not, err = p.selectorList(); err != nil {
+			return pseudo, err
+		}
+	} else {
+		end := strings.IndexByte(p.input[p.pos:], ')')
+		if end < 0 {
+			return pseudo, p.errorf("expected )")
+		}
+		if pseudo.a, pseudo.b, err = parseNth(p.input[p.pos : p.pos+end]); err != nil {
+			return pseudo, p.errorf("%s", err)
+		}
+		p.pos += end
+	}
+
+	if p.peek() != ')' {
+		return pseudo, p.errorf("expected )")
+	}
+	p.pos++
+	return pseudo, nil
+}
+
+// parseNth parses the an+b argument of the nth pseudo-classes, including
+// odd and even.
+func parseNth(argument string) (a int, b int, err error) {
+	argument = strings.ToLower(strings.TrimSpace(argument))
+	switch argument {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	}
+
+	match := nthRegExp.FindStringSubmatch(argument)
+	if match == nil {
+		return 0, 0, fmt.Errorf("invalid nth argument %q", argument)
+	}
+	if match[4] != "" {
+		b, err = strconv.Atoi(match[4])
+		return 0, b, err
+	}
+
+	switch match[1] {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		if a, err = strconv.Atoi(match[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if match[3] != "" {
+		if b, err = strconv.Atoi(match[3]); err != nil {
+			return 0, 0, err
+		}
+		if match[2] == "-" {
+			b = -b
+		}
+	}
+	return a, b, nil
+}
+
+func (p *selectorParser) attr() (attr attrSelector, err error) {
+	p.pos++ // [
+	p.skipSpace()
+	if attr.name = p.ident(); attr.name == "" {
+		return attr, p.errorf("expected an attribute name")
+	}
+	p.skipSpace()
+
+	if p.peek() == ']' {
+		p.pos++
+		return attr, nil
+	}
+
+	for _, operator := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.input[p.pos:], operator) {
+			attr.operator = operator
+			p.pos += len(operator)
+			break
+		}
+	}
+	if attr.operator == "" {
+		return attr, p.errorf("expected an attribute operator")
+	}
+	p.skipSpace()
+
+	if quote := p.peek(); quote == '"' || quote == '\'' {
+		end := strings.IndexByte(p.input[p.pos+1:], quote)
+		if end < 0 {
+			return attr, p.errorf("unterminated string")
+		}
+		attr.value = p.input[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else if attr.value = p.ident(); attr.value == "" {
+		return attr, p.errorf("expected an attribute value")
+	}
+	p.skipSpace()
+
+	if p.peek() == 'i' || p.peek() == 'I' {
+		attr.caseInsensitive = true
+		p.pos++
+		p.skipSpace()
+	}
+	if p.peek() != ']' {
+		return attr, p.errorf("expected ]")
+	}
+	p.pos++
+	return attr, nil
+}
+
+// ident reads a CSS identifier, with backslash escapes taken literally.
+func (p *selectorParser) ident() string {
+	var sb strings.Builder
+	for !p.done() {
+		c := p.peek()
+		if c == '\\' && p.pos+1 < len(p.input) {
+			sb.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if !isIdentStart(c) && !(c >= '0' && c <= '9') && c != '-' {
+			break
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return sb.String()
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '-' || c >= 0x80
+}
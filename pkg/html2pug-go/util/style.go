@@ -0,0 +1,77 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+var cssPropertyRegExp = regexp.MustCompile(`^(--[\w-]+|-?[a-zA-Z][a-zA-Z-]*)$`)
+
+// ParseStyleDeclarations splits an inline style attribute into its property
+// and value pairs. It reports false when the style cannot be parsed cleanly
+// (comments, unbalanced quotes or brackets, malformed or repeated
+// declarations), in which case the style should be kept as a string. A
+// repeated property is usually a fallback, which an object would collapse.
+func ParseStyleDeclarations(style string) (declarations [][2]string, ok bool) {
+	var current strings.Builder
+	var quote rune
+	depth := 0
+	seen := map[string]bool{}
+
+	flush := func() bool {
+		declaration := strings.TrimSpace(current.String())
+		current.Reset()
+		if declaration == "" {
+			return true
+		}
+		property, value, found := strings.Cut(declaration, ":")
+		property, value = strings.TrimSpace(property), strings.Join(strings.Fields(value), " ")
+		if !found || value == "" || !cssPropertyRegExp.MatchString(property) {
+			return false
+		}
+		if !strings.HasPrefix(property, "--") {
+			property = strings.ToLower(property)
+		}
+		if seen[property] {
+			return false
+		}
+		seen[property] = true
+		declarations = append(declarations, [2]string{property, value})
+		return true
+	}
+
+	if strings.Contains(style, "/*") {
+		return nil, false
+	}
+
+	for _, r := range style {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return nil, false
+			}
+		case r == '{' || r == '}':
+			return nil, false
+		case r == ';' && depth == 0:
+			if !flush() {
+				return nil, false
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+
+	if quote != 0 || depth != 0 || !flush() {
+		return nil, false
+	}
+	return declarations, len(declarations) > 0
+}
@@ -1,26 +1,134 @@
 package util
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"unicode"
 
 	html "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
+// booleanAttributes are the HTML boolean attributes, whose presence alone
+// means true, with the elements they apply to. Global attributes have no
+// element list.
+var booleanAttributes = map[string][]string{
+	"allowfullscreen": {"iframe"},
+	"async":           {"script"},
+	"autofocus":       nil,
+	"autoplay":        {"audio", "video"},
+	"checked":         {"input"},
+	"controls":        {"audio", "video"},
+	"default":         {"track"},
+	"defer":           {"script"},
+	"disabled":        {"button", "fieldset", "input", "link", "optgroup", "option", "select", "textarea"},
+	"formnovalidate":  {"button", "input"},
+	"hidden":          nil,
+	"inert":           nil,
+	"ismap":           {"img"},
+	"itemscope":       nil,
+	"loop":            {"audio", "video"},
+	"multiple":        {"input", "select"},
+	"muted":           {"audio", "video"},
+	"nomodule":        {"script"},
+	"novalidate":      {"form"},
+	"open":            {"details", "dialog"},
+	"playsinline":     {"video"},
+	"readonly":        {"input", "textarea"},
+	"required":        {"input", "select", "textarea"},
+	"reversed":        {"ol"},
+	"scoped":          {"style"},
+	"selected":        {"option"},
+}
+
+// The shorthand patterns mirror the pug-lexer id and className scanners,
+// /^#([\w-]+)/ and /^\.([_a-z0-9\-]*[_a-z][_a-z0-9\-]*)/i: ids may be any run
+// of ASCII word characters and hyphens, while class names additionally need
+// at least one letter or underscore. Both are ASCII only, as in JavaScript.
 var (
-	validJadeIdRegExp    = regexp.MustCompile(`^[\w\-]+$`)
-	validJadeClassRegExp = regexp.MustCompile(`^[\w\-]+$`)
+	validJadeIdRegExp    = regexp.MustCompile(`^[\w-]+$`)
+	validJadeClassRegExp = regexp.MustCompile(`(?i)^[_a-z0-9-]*[_a-z][_a-z0-9-]*$`)
 )
 
+// validJadeTagRegExp mirrors the pug-lexer tag scanner, /^(\w(?:[-:\w]*\w)?)/.
+var validJadeTagRegExp = regexp.MustCompile(`^\w(?:[-:\w]*\w)?$`)
+
+// plainAttributeNameRegExp matches attribute names Pug reads without quotes;
+// names with brackets, parentheses and the like (e.g. Angular's (click)) must
+// be quoted.
+var plainAttributeNameRegExp = regexp.MustCompile("^[^\\s()\\[\\]'\"`,=!]+$")
+
+var interpolationEscaper = strings.NewReplacer(
+	"#{", "\\#{",
+	"#[", "\\#[",
+	"!{", "\\!{",
+)
+
+// EscapeInterpolation escapes the Pug interpolation sequences #{, #[ and !{
+// in text, so they are output literally instead of being evaluated.
+func EscapeInterpolation(text string) string {
+	return interpolationEscaper.Replace(text)
+}
+
+// IsPlainAttributeName reports whether attrName can be written in an
+// attribute list without quotes.
+func IsPlainAttributeName(attrName string) bool {
+	return plainAttributeNameRegExp.MatchString(attrName)
+}
+
+// IsRepresentableAttributeName reports whether attrName can be written in a
+// Pug attribute list at all, quoted if need be.
+func IsRepresentableAttributeName(attrName string) bool {
+	if attrName == "" || strings.Contains(attrName, "'") && strings.Contains(attrName, `"`) {
+		return false
+	}
+	for _, r := range attrName {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidJadeTagName reports whether tagName can be written literally at the
+// start of a Pug line, rather than through #{'...'} interpolation.
+func IsValidJadeTagName(tagName string) (isValid bool) {
+	return validJadeTagRegExp.MatchString(tagName)
+}
+
+// IsValidJadeId reports whether id can be written as `#id` shorthand. The id
+// is used verbatim, so surrounding whitespace makes it invalid.
 func IsValidJadeId(id string) (isValid bool) {
-	id = strings.TrimSpace(id)
 	return id != "" && validJadeIdRegExp.MatchString(id)
 }
+
+// IsValidJadeClassName reports whether className can be written as `.class`
+// shorthand.
 func IsValidJadeClassName(className string) (isValid bool) {
-	className = strings.TrimSpace(className)
 	return className != "" && validJadeClassRegExp.MatchString(className)
 }
 
+// IsBooleanAttribute reports whether attrName is a boolean attribute of the
+// HTML element node. Foreign (SVG, MathML) elements have none.
+func IsBooleanAttribute(node *html.Node, attrName string) bool {
+	tagNames, ok := booleanAttributes[strings.ToLower(attrName)]
+	if !ok || node.Namespace != "" {
+		return false
+	}
+	if tagNames == nil {
+		return true
+	}
+	for _, tagName := range tagNames {
+		if strings.EqualFold(node.Data, tagName) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTextNode merges the adjacent text children of parent, changing
+// the tree in place.
 func NormalizeTextNode(parent *html.Node) {
 	var prev *html.Node
 
@@ -47,6 +155,36 @@ func NormalizeTextNode(parent *html.Node) {
 	}
 }
 
+// MergeTextNodes returns the text of first and its adjacent text siblings as
+// one node, and the last node of the run. A run of several nodes is merged
+// into a detached copy linked to the run's parent and outer siblings, so
+// the tree is left unchanged.
+func MergeTextNodes(first *html.Node) (merged *html.Node, last *html.Node) {
+	last = first
+	for last.NextSibling != nil && last.NextSibling.Type == html.TextNode {
+		last = last.NextSibling
+	}
+	if last == first {
+		return first, last
+	}
+
+	var data strings.Builder
+	for n := first; ; n = n.NextSibling {
+		data.WriteString(n.Data)
+		if n == last {
+			break
+		}
+	}
+	merged = &html.Node{
+		Type:        html.TextNode,
+		Data:        data.String(),
+		Parent:      first.Parent,
+		PrevSibling: first.PrevSibling,
+		NextSibling: last.NextSibling,
+	}
+	return merged, last
+}
+
 func HasAttr(node *html.Node, name string) bool {
 	for _, attr := range node.Attr {
 		if attr.Key == name {
@@ -64,3 +202,106 @@ func GetAttr(node *html.Node, name string) string {
 	}
 	return ""
 }
+
+// SetAttr sets the value of an attribute, adding it when missing.
+func SetAttr(node *html.Node, name string, value string) {
+	for i, attr := range node.Attr {
+		if attr.Key == name {
+			node.Attr[i].Val = value
+			return
+		}
+	}
+	node.Attr = append(node.Attr, html.Attribute{Key: name, Val: value})
+}
+
+func RemoveAttr(node *html.Node, name string) {
+	var attrs []html.Attribute
+	for _, attr := range node.Attr {
+		if attr.Key != name {
+			attrs = append(attrs, attr)
+		}
+	}
+	node.Attr = attrs
+}
+
+// RenameNode changes the tag name of an element.
+func RenameNode(node *html.Node, tagName string) {
+	node.Data = tagName
+	node.DataAtom = atom.Lookup([]byte(tagName))
+}
+
+// RemoveNode detaches node, with its children, from the tree.
+func RemoveNode(node *html.Node) {
+	if node.Parent != nil {
+		node.Parent.RemoveChild(node)
+	}
+}
+
+// UnwrapNode replaces node with its children.
+func UnwrapNode(node *html.Node) {
+	if node.Parent == nil {
+		return
+	}
+	for child := node.FirstChild; child != nil; child = node.FirstChild {
+		node.RemoveChild(child)
+		node.Parent.InsertBefore(child, node)
+	}
+	node.Parent.RemoveChild(node)
+}
+
+// CloneNode returns a deep copy of node, detached from its tree. When clones
+// is not nil, each copied node is recorded there against its copy.
+func CloneNode(node *html.Node, clones map[*html.Node]*html.Node) *html.Node {
+	clone := &html.Node{
+		Type:      node.Type,
+		DataAtom:  node.DataAtom,
+		Data:      node.Data,
+		Namespace: node.Namespace,
+		Attr:      append([]html.Attribute(nil), node.Attr...),
+	}
+	if clones != nil {
+		clones[node] = clone
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		clone.AppendChild(CloneNode(child, clones))
+	}
+	return clone
+}
+
+// IsAncestor reports whether ancestor contains node.
+func IsAncestor(ancestor *html.Node, node *html.Node) bool {
+	for n := node.Parent; n != nil; n = n.Parent {
+		if n == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// NodePath locates node in its tree as an XPath-like path of element names
+// with 1-based indexes among same-named siblings, e.g. /html[1]/body[1]/div[2].
+// Other node types end the path with text(), comment() and so on.
+func NodePath(node *html.Node) string {
+	var steps []string
+	for n := node; n != nil && n.Type != html.DocumentNode; n = n.Parent {
+		var step string
+		switch n.Type {
+		case html.ElementNode:
+			index := 1
+			for sibling := n.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+				if sibling.Type == html.ElementNode && sibling.Data == n.Data {
+					index++
+				}
+			}
+			step = fmt.Sprintf("%s[%d]", n.Data, index)
+		case html.TextNode, html.RawNode:
+			step = "text()"
+		case html.CommentNode:
+			step = "comment()"
+		case html.DoctypeNode:
+			step = "doctype()"
+		}
+		steps = append([]string{step}, steps...)
+	}
+	return "/" + strings.Join(steps, "/")
+}
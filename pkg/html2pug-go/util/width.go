@@ -0,0 +1,74 @@
+package util
+
+import (
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+const (
+	zeroWidthJoiner    = '\u200d'
+	emojiPresentation  = '\ufe0f'
+	skinToneFirst      = '\U0001f3fb'
+	skinToneLast       = '\U0001f3ff'
+	regionalIndicatorA = '\U0001f1e6'
+	regionalIndicatorZ = '\U0001f1ff'
+)
+
+// DisplayWidth returns the number of terminal columns s occupies: East Asian
+// wide and fullwidth characters count as two, combining marks and other
+// extending characters count as zero, and emoji sequences joined with ZWJ,
+// skin tone modifiers or flag pairs count as a single two column cluster.
+func DisplayWidth(s string) (columns int) {
+	previous := rune(-1)
+	clusterWidth := 0
+	regionalIndicators := 0
+
+	for _, r := range s {
+		switch {
+		case previous == zeroWidthJoiner:
+			// Joined to the preceding emoji
+		case r == emojiPresentation:
+			if clusterWidth == 1 {
+				columns++
+				clusterWidth = 2
+			}
+		case r == zeroWidthJoiner || isExtend(r):
+		case r >= skinToneFirst && r <= skinToneLast && previous >= 0 && RuneWidth(previous) == 2:
+		case r >= regionalIndicatorA && r <= regionalIndicatorZ:
+			// Every pair of regional indicators forms one flag
+			regionalIndicators++
+			if regionalIndicators%2 == 1 {
+				clusterWidth = 2
+				columns += clusterWidth
+			}
+		default:
+			clusterWidth = RuneWidth(r)
+			columns += clusterWidth
+		}
+
+		if r < regionalIndicatorA || r > regionalIndicatorZ {
+			regionalIndicators = 0
+		}
+		previous = r
+	}
+
+	return
+}
+
+// RuneWidth returns the number of columns a single rune occupies.
+func RuneWidth(r rune) int {
+	if isExtend(r) || r == zeroWidthJoiner || r == emojiPresentation || !unicode.IsPrint(r) && r != ' ' {
+		return 0
+	}
+
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+func isExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me) || (r >= '\ufe00' && r <= '\ufe0e') || r == '\u200c'
+}
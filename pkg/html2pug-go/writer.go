@@ -1,7 +1,9 @@
 package pkg
 
 import (
+	"encoding/json"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
@@ -10,14 +12,24 @@ import (
 )
 
 type Writer struct {
-	Options          *entities.Html2JadeConvertorOptions
-	WrapLength       int
-	Scalate          bool
-	AttrSep          string
-	AttrQuote        string
-	NonAttrQuote     string
-	AttrQuoteEscaped string
-	NoEmptyPipe      bool
+	Options                   *entities.Html2JadeConvertorOptions
+	WrapLength                int
+	InlineTextLength          int
+	Scalate                   bool
+	AttrSep                   string
+	AttrQuote                 string
+	NonAttrQuote              string
+	AttrQuoteEscaped          string
+	NoEmptyPipe               bool
+	WrapAttributes            bool
+	TemplateLiterals          bool
+	ExplicitBooleanAttributes bool
+	StyleObjects              bool
+	DataObjects               bool
+	ClassMode                 entities.ClassMode
+	// document is the document being converted, set on the copy made for
+	// each conversion, see Convertor.Document
+	document *entities.Document
 }
 
 // NewWriter
@@ -30,6 +42,10 @@ func NewWriter(options *entities.Html2JadeConvertorOptions) (convertor entities.
 	if options.WriterOptions.WrapLength != nil {
 		wrapLength = *options.WriterOptions.WrapLength
 	}
+	inlineTextLength := wrapLength
+	if options.WriterOptions.InlineTextLength != nil {
+		inlineTextLength = *options.WriterOptions.InlineTextLength
+	}
 	scalate := false
 	if options.WriterOptions.Scalate != nil {
 		scalate = *options.WriterOptions.Scalate
@@ -62,15 +78,47 @@ func NewWriter(options *entities.Html2JadeConvertorOptions) (convertor entities.
 		noEmptyPipe = *options.WriterOptions.NoEmptyPipe
 	}
 
+	wrapAttributes := false
+	if options.WriterOptions.WrapAttributes != nil {
+		wrapAttributes = *options.WriterOptions.WrapAttributes
+	}
+
+	templateLiterals := false
+	if options.WriterOptions.TemplateLiterals != nil {
+		templateLiterals = *options.WriterOptions.TemplateLiterals
+	}
+
+	explicitBooleanAttributes := false
+	if options.WriterOptions.ExplicitBooleanAttributes != nil {
+		explicitBooleanAttributes = *options.WriterOptions.ExplicitBooleanAttributes
+	}
+
+	styleObjects := false
+	if options.WriterOptions.StyleObjects != nil {
+		styleObjects = *options.WriterOptions.StyleObjects
+	}
+
+	dataObjects := false
+	if options.WriterOptions.DataObjects != nil {
+		dataObjects = *options.WriterOptions.DataObjects
+	}
+
 	convertor = &Writer{
-		Options:          options,
-		WrapLength:       wrapLength,
-		Scalate:          scalate,
-		AttrSep:          attrSep,
-		AttrQuote:        attrQuote,
-		NonAttrQuote:     nonAttrQuote,
-		AttrQuoteEscaped: attrQuoteEscaped,
-		NoEmptyPipe:      noEmptyPipe,
+		Options:                   options,
+		WrapLength:                wrapLength,
+		InlineTextLength:          inlineTextLength,
+		Scalate:                   scalate,
+		AttrSep:                   attrSep,
+		AttrQuote:                 attrQuote,
+		NonAttrQuote:              nonAttrQuote,
+		AttrQuoteEscaped:          attrQuoteEscaped,
+		NoEmptyPipe:               noEmptyPipe,
+		WrapAttributes:            wrapAttributes,
+		TemplateLiterals:          templateLiterals,
+		ExplicitBooleanAttributes: explicitBooleanAttributes,
+		StyleObjects:              styleObjects,
+		DataObjects:               dataObjects,
+		ClassMode:                 options.WriterOptions.ClassMode,
 	}
 	return
 }
@@ -96,7 +144,7 @@ func (w *Writer) BreakLine(line string) []string {
 		words = words[1:]
 
 		// If adding the word exceeds the wrapLength, push the current line and start a new one
-		if len(currentLine)+len(word) > w.WrapLength {
+		if len(currentLine) > 0 && util.DisplayWidth(currentLine)+util.DisplayWidth(word) > w.WrapLength {
 			lines = append(lines, currentLine)
 			currentLine = word
 		} else if len(currentLine) > 0 {
@@ -116,8 +164,20 @@ func (w *Writer) BreakLine(line string) []string {
 	return lines
 }
 
+// AttributeName quotes attrName when Pug cannot read it unquoted.
+func (w *Writer) AttributeName(attrName string) string {
+	if util.IsPlainAttributeName(attrName) {
+		return attrName
+	}
+	if strings.Contains(attrName, w.AttrQuote) {
+		return w.NonAttrQuote + attrName + w.NonAttrQuote
+	}
+	return w.AttrQuote + attrName + w.AttrQuote
+}
+
 // BuildTagAttribute implements entities.IWriter.
 func (w *Writer) BuildTagAttribute(attrName string, attrValue string) string {
+	attrName = w.AttributeName(attrName)
 	if !strings.Contains(attrValue, w.AttrQuote) {
 		return attrName + "=" + w.AttrQuote + attrValue + w.AttrQuote
 	} else if !strings.Contains(attrValue, w.NonAttrQuote) {
@@ -128,6 +188,143 @@ func (w *Writer) BuildTagAttribute(attrName string, attrValue string) string {
 	}
 }
 
+var templateLiteralEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"`", "\\`",
+	"${", "\\${",
+	"\r\n", "\n",
+	"\r", "\n",
+)
+
+// BuildTemplateLiteralAttribute writes the value as a backtick template
+// literal, so its newlines can be kept as they are.
+func (w *Writer) BuildTemplateLiteralAttribute(attrName string, attrValue string) string {
+	return w.AttributeName(attrName) + "=`" + templateLiteralEscaper.Replace(attrValue) + "`"
+}
+
+var jsIdentifierRegExp = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// BuildStyleObjectAttribute writes a style attribute as a Pug style object.
+// It reports false when the style cannot be parsed into declarations.
+func (w *Writer) BuildStyleObjectAttribute(attrName string, attrValue string) (string, bool) {
+	declarations, ok := util.ParseStyleDeclarations(attrValue)
+	if !ok {
+		return "", false
+	}
+
+	properties := make([]string, 0, len(declarations))
+	for _, declaration := range declarations {
+		key := declaration[0]
+		if !jsIdentifierRegExp.MatchString(key) {
+			key = w.QuoteJSString(key)
+		}
+		properties = append(properties, key+": "+w.QuoteJSString(declaration[1]))
+	}
+
+	return w.AttributeName(attrName) + "={" + strings.Join(properties, ", ") + "}", true
+}
+
+// BuildDataObjectAttribute writes a data-* attribute holding a JSON object or
+// array as a JavaScript literal, which Pug serialises back to JSON. It
+// reports false when the value is not a JSON object or array.
+func (w *Writer) BuildDataObjectAttribute(attrName string, attrValue string) (string, bool) {
+	trimmed := strings.TrimSpace(attrValue)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") || !json.Valid([]byte(trimmed)) {
+		return "", false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var literal strings.Builder
+	if err := w.writeJSLiteral(decoder, &literal); err != nil {
+		return "", false
+	}
+	return w.AttributeName(attrName) + "=" + literal.String(), true
+}
+
+// writeJSLiteral copies the next JSON value from decoder as a JavaScript
+// literal, keeping the key order of objects.
+func (w *Writer) writeJSLiteral(decoder *json.Decoder, literal *strings.Builder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		closing := "]"
+		if token == '{' {
+			closing = "}"
+		}
+		literal.WriteString(token.String())
+		for i := 0; decoder.More(); i++ {
+			if i > 0 {
+				literal.WriteString(", ")
+			}
+			if token == '{' {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				// A plain __proto__ key, quoted or not, sets the prototype
+				// instead, a computed key does not
+				if key == "__proto__" {
+					literal.WriteString("[" + w.QuoteJSString(key.(string)) + "]")
+				} else if jsIdentifierRegExp.MatchString(key.(string)) {
+					literal.WriteString(key.(string))
+				} else {
+					literal.WriteString(w.QuoteJSString(key.(string)))
+				}
+				literal.WriteString(": ")
+			}
+			if err := w.writeJSLiteral(decoder, literal); err != nil {
+				return err
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+		literal.WriteString(closing)
+	case string:
+		literal.WriteString(w.QuoteJSString(token))
+	case json.Number:
+		literal.WriteString(token.String())
+	case bool:
+		literal.WriteString(strconv.FormatBool(token))
+	case nil:
+		literal.WriteString("null")
+	}
+	return nil
+}
+
+func (w *Writer) dataObjectAttribute(attrName string, attrValue string) (string, bool) {
+	if !w.DataObjects || !strings.HasPrefix(attrName, "data-") {
+		return "", false
+	}
+	return w.BuildDataObjectAttribute(attrName, attrValue)
+}
+
+// QuoteJSString quotes value as a JavaScript string using the attribute quote.
+func (w *Writer) QuoteJSString(value string) string {
+	value = strings.NewReplacer(
+		"\\", "\\\\",
+		w.AttrQuote, w.AttrQuoteEscaped,
+		"\n", "\\n",
+		"\r", "\\r",
+		"\u2028", "\\u2028",
+		"\u2029", "\\u2029",
+	).Replace(value)
+	return w.AttrQuote + value + w.AttrQuote
+}
+
+func (w *Writer) styleObjectAttribute(attrName string, attrValue string) (string, bool) {
+	if !w.StyleObjects || attrName != "style" {
+		return "", false
+	}
+	return w.BuildStyleObjectAttribute(attrName, attrValue)
+}
+
 // ForEachChild implements entities.IWriter.
 func (w *Writer) ForEachChild(parent *html.Node, cb func(child *html.Node)) {
 	if parent == nil {
@@ -159,17 +356,26 @@ func (w *Writer) TagAttribute(node *html.Node, indents string) string {
 			// Skip valid IDs (they're already used in TagHead)
 			continue
 		} else if attrName == "class" {
-			classNames := strings.Fields(attrValue)
-			var invalidClassNames []string
-			for _, name := range classNames {
-				if name != "" && !util.IsValidJadeClassName(name) {
-					invalidClassNames = append(invalidClassNames, name)
+			_, invalidClassNames, asArray := w.Classes(attrValue)
+			if asArray {
+				quoted := make([]string, 0, len(invalidClassNames))
+				for _, name := range invalidClassNames {
+					quoted = append(quoted, w.QuoteJSString(name))
 				}
-			}
-			if len(invalidClassNames) > 0 {
+				result = append(result, "class=["+strings.Join(quoted, ", ")+"]")
+			} else if len(invalidClassNames) > 0 {
 				joined := strings.Join(invalidClassNames, " ")
 				result = append(result, w.BuildTagAttribute(attrName, joined))
 			}
+		} else if !w.ExplicitBooleanAttributes && util.IsBooleanAttribute(node, attrName) && (attrValue == "" || strings.EqualFold(attrValue, attrName)) {
+			// Terse boolean attribute, e.g. input(disabled)
+			result = append(result, attrName)
+		} else if styleObject, ok := w.styleObjectAttribute(attrName, attrValue); ok {
+			result = append(result, styleObject)
+		} else if dataObject, ok := w.dataObjectAttribute(attrName, attrValue); ok {
+			result = append(result, dataObject)
+		} else if w.TemplateLiterals && strings.ContainsAny(attrValue, "\r\n") {
+			result = append(result, w.BuildTemplateLiteralAttribute(attrName, attrValue))
 		} else {
 			// Replace newlines + optional whitespace with \n and the indent
 			re := regexp.MustCompile(`(\r|\n)\s*`)
@@ -178,10 +384,60 @@ func (w *Writer) TagAttribute(node *html.Node, indents string) string {
 		}
 	}
 
-	if len(result) > 0 {
-		return "(" + strings.Join(result, w.AttrSep) + ")"
+	if len(result) == 0 {
+		return ""
+	}
+
+	tagAttribute := "(" + strings.Join(result, w.AttrSep) + ")"
+	if !w.WrapAttributes || len(result) < 2 || util.DisplayWidth(indents+w.TagHead(node)+tagAttribute) <= w.WrapLength {
+		return tagAttribute
+	}
+
+	// One attribute per line, nested under the tag
+	attrIndents := indents + w.indentUnit()
+	lineSep := strings.TrimRight(w.AttrSep, " ") + "\n" + attrIndents
+	return "(\n" + attrIndents + strings.Join(result, lineSep) + "\n" + indents + ")"
+}
+
+func (w *Writer) indentUnit() string {
+	if w.Options.UseTabs {
+		return "\t"
+	}
+	if w.Options.NSpaces > 0 {
+		return strings.Repeat(" ", w.Options.NSpaces)
+	}
+	return "  "
+}
+
+// Classes splits the class names of a class attribute into those written as
+// `.class` shorthand and those written in the class attribute, according to
+// the ClassMode. asArray is set when the attribute classes are written as a
+// class array.
+func (w *Writer) Classes(classAttr string) (shorthand []string, attribute []string, asArray bool) {
+	classNames := strings.Fields(classAttr)
+	firstInvalid := len(classNames)
+	for i, name := range classNames {
+		if util.IsValidJadeClassName(name) {
+			if i < firstInvalid || w.ClassMode == entities.SplitClassMode {
+				shorthand = append(shorthand, name)
+			}
+		} else {
+			if firstInvalid == len(classNames) {
+				firstInvalid = i
+			}
+			attribute = append(attribute, name)
+		}
+	}
+
+	if w.ClassMode == entities.SplitClassMode || len(attribute) == 0 {
+		return shorthand, attribute, false
 	}
-	return ""
+
+	if w.ClassMode == entities.TailwindClassMode && len(shorthand) > 0 {
+		// The shorthand classes come first in the rendered class list
+		return shorthand, classNames[firstInvalid:], true
+	}
+	return nil, classNames, true
 }
 
 // TagHead implements entities.IWriter.
@@ -191,9 +447,17 @@ func (w *Writer) TagHead(node *html.Node) string {
 	}
 
 	tagName := node.Data
+	// Foreign (SVG, MathML) and namespace-prefixed XML tag names are case
+	// sensitive, e.g. linearGradient or h:inputText
+	if node.Namespace == "" && !strings.Contains(tagName, ":") {
+		tagName = strings.ToLower(tagName)
+	}
+
 	result := ""
-	if strings.ToLower(tagName) != "div" {
-		result = strings.ToLower(tagName)
+	if !util.IsValidJadeTagName(tagName) {
+		result = "#{" + w.QuoteJSString(tagName) + "}"
+	} else if tagName != "div" {
+		result = tagName
 	}
 
 	var id string
@@ -213,13 +477,7 @@ func (w *Writer) TagHead(node *html.Node) string {
 	}
 
 	if classAttr != "" {
-		classNames := strings.Fields(classAttr)
-		validClassNames := []string{}
-		for _, name := range classNames {
-			if name != "" && util.IsValidJadeClassName(name) {
-				validClassNames = append(validClassNames, name)
-			}
-		}
+		validClassNames, _, _ := w.Classes(classAttr)
 		if len(validClassNames) > 0 {
 			result += "." + strings.Join(validClassNames, ".")
 		}
@@ -243,13 +501,14 @@ func (w *Writer) TagText(node *html.Node) *string {
 		return nil
 	}
 
-	// Make sure it's the only child
-	if first != node.LastChild {
+	// Make sure it's the only child, adjacent text nodes counting as one
+	text, last := util.MergeTextNodes(first)
+	if last != node.LastChild {
 		return nil
 	}
 
-	data := first.Data
-	if len(data) > w.WrapLength || regexp.MustCompile(`\r|\n`).MatchString(data) {
+	data := text.Data
+	if util.DisplayWidth(data) > w.InlineTextLength || regexp.MustCompile(`\r|\n`).MatchString(data) {
 		return nil
 	}
 
@@ -258,7 +517,7 @@ func (w *Writer) TagText(node *html.Node) *string {
 
 // WriteText implements entities.IWriter.
 func (w *Writer) WriteText(node *html.Node, output *entities.IStringWriter, textOptions entities.TextOptions) {
-	if node.Type == html.TextNode {
+	if node.Type == html.TextNode || node.Type == html.RawNode {
 		data := node.Data
 		if len(data) > 0 {
 			re := regexp.MustCompile(`\r|\n`)
@@ -283,6 +542,9 @@ func (w *Writer) WriteTextContent(node *html.Node, output *entities.IStringWrite
 func (w *Writer) WriteTextLine(node *html.Node, line string, output *entities.IStringWriter, textOptions entities.TextOptions) {
 	// Handle pipe and noEmptyPipe
 	if textOptions.Pipe && w.NoEmptyPipe && len(strings.TrimSpace(line)) == 0 {
+		if line != "" && node != nil {
+			traceNode(w.Options, w.document, node, entities.TraceEvent{Decision: entities.DropEmptyPipeTraceDecision, Text: line})
+		}
 		return
 	}
 
@@ -293,6 +555,7 @@ func (w *Writer) WriteTextLine(node *html.Node, line string, output *entities.IS
 	}
 
 	// Trim the line if needed
+	untrimmed := line
 	// if the node is not nil, and previous sibling is not nil and the previous sibling type is an element
 	if node != nil && node.PrevSibling != nil && node.PrevSibling.Type != html.ElementNode {
 		line = strings.TrimLeft(line, " ")
@@ -302,18 +565,25 @@ func (w *Writer) WriteTextLine(node *html.Node, line string, output *entities.IS
 	if node != nil && node.NextSibling != nil && node.NextSibling.Type != html.ElementNode {
 		line = strings.TrimRight(line, " ")
 	}
+	if line != untrimmed {
+		traceNode(w.Options, w.document, node, entities.TraceEvent{Decision: entities.TrimTextTraceDecision, Text: untrimmed})
+	}
 
 	// Handle non-empty line
 	if len(line) > 0 {
 		if textOptions.EncodeEntityRef {
-			line = html.EscapeString(line)
+			line = util.EscapeInterpolation(html.EscapeString(line))
 		}
 
 		if textOptions.EscapeBackslash {
 			line = strings.ReplaceAll(line, "\\", "\\\\")
 		}
 
-		if !textOptions.Wrap || len(line) <= w.WrapLength {
+		if textOptions.EscapeInterpolation && !textOptions.EncodeEntityRef {
+			line = util.EscapeInterpolation(line)
+		}
+
+		if !textOptions.Wrap || util.DisplayWidth(line) <= w.WrapLength {
 			(*output).WriteLine(prefix+line, true)
 		} else {
 			// Split the line if it's too long
@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	html "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// XMLParser parses XHTML input with encoding/xml into the same html.Node tree
+// the HTML5 parser builds. Self-closed elements stay empty, CDATA sections
+// become RawNode children converted as block text, and the XML prolog becomes
+// a doctype named "xml".
+type XMLParser struct {
+	Options *entities.Html2JadeConvertorOptions
+}
+
+// NewXMLParser
+func NewXMLParser(options *entities.Html2JadeConvertorOptions) (parser entities.IParser) {
+	parser = &XMLParser{
+		Options: options,
+	}
+	return
+}
+
+func (p *XMLParser) Parse(htmlContentReader io.Reader, callback entities.ParserCallback) {
+	window := entities.Window{}
+
+	var errors []error
+	doc, positions, err := p.parse(htmlContentReader)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	window.Document = &entities.Document{
+		Root:      doc,
+		Positions: positions,
+	}
+
+	callback(errors, window)
+}
+
+// xmlVoidElements are the HTML elements that never have content, closed
+// even when not self-closed in the XHTML.
+var xmlVoidElements = func() map[string]bool {
+	elements := map[string]bool{}
+	for _, name := range xml.HTMLAutoClose {
+		elements[name] = true
+	}
+	return elements
+}()
+
+func (p *XMLParser) parse(reader io.Reader) (*html.Node, map[*html.Node]entities.Position, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.Options.DetectCharset {
+		if content, err = p.decode(content); err != nil {
+			return nil, nil, err
+		}
+	}
+	content = bytes.TrimPrefix(content, utf8BOM)
+
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// The content is UTF-8 by now, whatever the prolog says, so that
+		// decoder offsets index into it
+		return input, nil
+	}
+
+	doc := &html.Node{Type: html.DocumentNode}
+	parent := doc
+	var prolog *html.Node
+	hasDoctype := false
+	positions := map[*html.Node]entities.Position{}
+	position := entities.Position{Line: 1, Column: 1}
+
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return doc, positions, err
+		}
+		position = advancePosition(position, content[position.Offset:offset])
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			element := &html.Node{
+				Type: html.ElementNode,
+				Data: xmlName(token.Name),
+			}
+			if token.Name.Space == "" {
+				element.DataAtom = atom.Lookup([]byte(token.Name.Local))
+			}
+			for _, attr := range token.Attr {
+				element.Attr = append(element.Attr, html.Attribute{
+					Key: xmlName(attr.Name),
+					Val: attr.Value,
+				})
+			}
+			positions[element] = position
+			parent.AppendChild(element)
+			// RawToken does not apply AutoClose, so void elements written
+			// without a closing slash are closed here
+			if token.Name.Space != "" || !xmlVoidElements[strings.ToLower(token.Name.Local)] {
+				parent = element
+			}
+		case xml.EndElement:
+			// Unbalanced end tags are ignored rather than closing the wrong element
+			for n := parent; n != nil && n != doc; n = n.Parent {
+				if n.Data == xmlName(token.Name) {
+					parent = n.Parent
+					break
+				}
+			}
+		case xml.CharData:
+			if bytes.HasPrefix(content[offset:], []byte("<![CDATA[")) {
+				parent.AppendChild(&html.Node{Type: html.RawNode, Data: string(token)})
+			} else if parent != doc {
+				parent.AppendChild(&html.Node{Type: html.TextNode, Data: string(token)})
+			}
+		case xml.Comment:
+			comment := &html.Node{Type: html.CommentNode, Data: string(token)}
+			positions[comment] = position
+			parent.AppendChild(comment)
+		case xml.ProcInst:
+			if token.Target == "xml" {
+				prolog = &html.Node{Type: html.DoctypeNode, Data: "xml"}
+			} else {
+				parent.AppendChild(&html.Node{
+					Type: html.RawNode,
+					Data: "<?" + token.Target + " " + string(token.Inst) + "?>",
+				})
+			}
+		case xml.Directive:
+			if doctype, ok := xmlDoctype(string(token)); ok {
+				hasDoctype = true
+				doc.AppendChild(doctype)
+			}
+		}
+	}
+
+	// An explicit DOCTYPE names the document type more precisely than the prolog
+	if prolog != nil && !hasDoctype {
+		doc.InsertBefore(prolog, doc.FirstChild)
+	}
+
+	return doc, positions, nil
+}
+
+// xmlEncoding matches the encoding declaration of an XML prolog.
+var xmlEncoding = regexp.MustCompile(`^<\?xml[^>]*?\sencoding\s*=\s*["']([^"']+)["']`)
+
+// decode converts content to UTF-8 using its BOM, the charset parameter of
+// Options.ContentType or the prolog encoding, in that order.
+func (p *XMLParser) decode(content []byte) ([]byte, error) {
+	label := ""
+	switch {
+	case bytes.HasPrefix(content, utf8BOM):
+		return content, nil
+	case bytes.HasPrefix(content, []byte{0xfe, 0xff}):
+		label = "utf-16be"
+	case bytes.HasPrefix(content, []byte{0xff, 0xfe}):
+		label = "utf-16le"
+	}
+	if label == "" && p.Options.ContentType != "" {
+		if _, params, err := mime.ParseMediaType(p.Options.ContentType); err == nil {
+			label = params["charset"]
+		}
+	}
+	if label == "" {
+		if match := xmlEncoding.FindSubmatch(content); match != nil {
+			label = string(match[1])
+		}
+	}
+	if label == "" {
+		return content, nil
+	}
+
+	encoding, _ := charset.Lookup(label)
+	if encoding == nil {
+		return nil, fmt.Errorf("unsupported charset: %q", label)
+	}
+	return encoding.NewDecoder().Bytes(content)
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// xmlDoctype builds a DoctypeNode from a <!DOCTYPE ...> directive, with the
+// public and system identifiers stored as attributes like the HTML5 parser.
+func xmlDoctype(directive string) (*html.Node, bool) {
+	fields := strings.Fields(directive)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "DOCTYPE") {
+		return nil, false
+	}
+
+	doctype := &html.Node{Type: html.DoctypeNode, Data: strings.ToLower(fields[1])}
+
+	var ids []string
+	for rest := directive; ; {
+		start := strings.IndexAny(rest, `"'`)
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start+1:], rest[start])
+		if end < 0 {
+			break
+		}
+		ids = append(ids, rest[start+1:start+1+end])
+		rest = rest[start+1+end+1:]
+	}
+
+	keyword := ""
+	if len(fields) > 2 {
+		keyword = strings.ToUpper(fields[2])
+	}
+	switch {
+	case keyword == "PUBLIC" && len(ids) > 0:
+		doctype.Attr = append(doctype.Attr, html.Attribute{Key: "public", Val: ids[0]})
+		if len(ids) > 1 {
+			doctype.Attr = append(doctype.Attr, html.Attribute{Key: "system", Val: ids[1]})
+		}
+	case keyword == "SYSTEM" && len(ids) > 0:
+		doctype.Attr = append(doctype.Attr, html.Attribute{Key: "system", Val: ids[0]})
+	}
+
+	return doctype, true
+}
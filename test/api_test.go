@@ -1,13 +1,20 @@
 package pkg_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	pkg "github.com/chrisbward/html2pug-go/pkg/html2pug-go"
 	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/entities"
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
 	"github.com/sirupsen/logrus"
 	assert "github.com/stretchr/testify/assert"
 	_ "go.uber.org/mock/gomock"
+	html "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 func TestConvert(t *testing.T) {
@@ -19,6 +26,112 @@ func TestConvert(t *testing.T) {
 		NSpaces:  2,
 		KeepHead: true,
 	}
+	assetOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces:          2,
+		OutDirectoryPath: t.TempDir(),
+		ExtractAssets: &entities.AssetOptions{
+			Scripts:  true,
+			Styles:   true,
+			SVGs:     true,
+			DataURIs: true,
+		},
+	}
+	assetFilterOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces:          2,
+		Bodyless:         true,
+		OutDirectoryPath: t.TempDir(),
+		ExtractAssets: &entities.AssetOptions{
+			Scripts: true,
+			Styles:  true,
+		},
+	}
+	inlineTextLength := 10
+	wrapAttributes := true
+	noAttrComma := true
+	templateLiterals := true
+	explicitBooleanAttributes := true
+	styleObjects := true
+	dataObjects := true
+	tagHandlerOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces: 2,
+		TagHandlers: map[string]entities.TagHandler{
+			"iframe": func(ctx *entities.TagContext) {
+				(*ctx.Output).WriteLine("+embed"+ctx.TagAttr, true)
+			},
+			"video": func(ctx *entities.TagContext) {
+				(*ctx.Output).WriteLine("//- video", true)
+				ctx.Next()
+			},
+		},
+		PredicateTagHandlers: []entities.PredicateTagHandler{
+			{
+				Match: func(node *html.Node) bool {
+					return strings.Contains(node.Data, "-")
+				},
+				Handle: func(ctx *entities.TagContext) {
+					(*ctx.Output).WriteLine("+component('"+ctx.TagName+"')"+ctx.TagAttr, true)
+					(*ctx.Convertor).Children(ctx.Node, ctx.Output, true)
+				},
+			},
+		},
+	}
+	rawHandlerOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces:  2,
+		Bodyless: true,
+		PredicateTagHandlers: []entities.PredicateTagHandler{
+			{
+				Match: func(node *html.Node) bool {
+					return util.HasAttr(node, "a'b\"c")
+				},
+				Handle: func(ctx *entities.TagContext) {
+					(*ctx.Output).WriteLine("//- "+ctx.TagName, true)
+					ctx.Next()
+				},
+			},
+		},
+	}
+	transformOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces:  2,
+		Bodyless: true,
+		Transforms: []entities.Transform{
+			{
+				// Drop tracking pixels
+				Enter: func(node *html.Node) {
+					if node.Data == "img" && util.GetAttr(node, "width") == "1" && util.GetAttr(node, "height") == "1" {
+						util.RemoveNode(node)
+					}
+				},
+			},
+			{
+				Enter: func(node *html.Node) {
+					switch {
+					case node.Data == "div" && util.GetAttr(node, "class") == "wrapper":
+						util.UnwrapNode(node)
+					case node.Data == "b":
+						util.RenameNode(node, "strong")
+					case node.Data == "a" && strings.HasPrefix(util.GetAttr(node, "href"), "http:"):
+						util.SetAttr(node, "href", "https:"+strings.TrimPrefix(util.GetAttr(node, "href"), "http:"))
+						util.RemoveAttr(node, "target")
+					}
+				},
+				Leave: func(node *html.Node) {
+					// Children are transformed before their parent leaves
+					if node.Data == "p" && node.FirstChild != nil && node.FirstChild.Data == "strong" {
+						util.SetAttr(node, "class", "lead")
+					}
+				},
+			},
+		},
+	}
+	selectOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces: 2,
+		Select:  "main, #app, .card",
+	}
+	selectBlockOptions := &entities.Html2JadeConvertorOptions{
+		NSpaces:     2,
+		Select:      "#app",
+		SelectBlock: "content",
+	}
 	doSKip := true
 
 	type TestCase struct {
@@ -27,7 +140,10 @@ func TestConvert(t *testing.T) {
 		Options      *entities.Html2JadeConvertorOptions
 		SourceHTML   string
 		ExpectedJade string
-		NilAssertion func(t assert.TestingT, object interface{}, msgAndArgs ...interface{}) bool
+		// ExpectedFiles maps the asset files written under OutDirectoryPath
+		// to their content
+		ExpectedFiles map[string]string
+		NilAssertion  func(t assert.TestingT, object interface{}, msgAndArgs ...interface{}) bool
 	}
 
 	testCases := []TestCase{
@@ -2213,28 +2329,1564 @@ html
 `,
 			NilAssertion: assert.Nil,
 		},
-	}
 
-	for _, tc := range testCases {
-		logrus.Infoln("test case " + tc.Desc)
+		{
+			Desc:    "TEST024 - Asset extraction",
+			Options: assetOptions,
+			SourceHTML: `<p>assets</p>
+<style media="print">p { color: red; }</style>
+<script>var a = 1;</script>
+<script type="text/template"><b>kept</b></script>
+<svg><path d="M0 0"/></svg>
+<img src="data:image/png;base64,iVBORw0KGgo=">
+<img src="data:image/png;base64,iVBORw0KGgo=">
+`,
+			ExpectedJade: `html
+  body
+    p assets
+    link(rel='stylesheet', href='assets/a5c906bfd62f35c0.css', media='print')
+    script(src='assets/f9d67ab9db16c4d5.js')
+    script(type='text/template').
+      <b>kept</b>
+    include assets/b0303396f6545a95.svg
+    img(src='assets/4c4b6a3be1314ab8.png')
+    img(src='assets/4c4b6a3be1314ab8.png')
+`,
+			ExpectedFiles: map[string]string{
+				"assets/a5c906bfd62f35c0.css": "p { color: red; }",
+				"assets/f9d67ab9db16c4d5.js":  "var a = 1;",
+				"assets/b0303396f6545a95.svg": `<svg><path d="M0 0"></path></svg>`,
+				"assets/4c4b6a3be1314ab8.png": "\x89PNG\r\n\x1a\n",
+			},
+			NilAssertion: assert.Nil,
+		},
 
-		tc := tc
-		if tc.Skip {
-			logrus.Warnln("skipping " + tc.Desc)
-			// t.Skip(tc.Desc)
-			continue
-		}
+		{
+			Desc: "TEST025 - Filters",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				Filters: map[string]entities.PugFilter{
+					"less":      {},
+					"jsx":       {Name: "babel"},
+					"text/x-md": {Name: "markdown-it", Bare: true},
+				},
+			},
+			SourceHTML: `<p>filters</p>
+<style lang="scss" scoped>
+.a { .b { color: red; } }
+</style>
+<style lang="less">
+.a { .b; }
+</style>
+<script type="text/typescript">
+let a: number = 1;
+</script>
+<script type="text/markdown">
+# Data
+</script>
+<script type="text/x-md">
+# Title
+</script>
+<script lang="jsx">
+render(<App />);
+</script>
+`,
+			ExpectedJade: `html
+  body
+    p filters
+    style(scoped)
+      :scss
+        .a { .b { color: red; } }
+    style(lang='less').
+      .a { .b; }
+    script
+      :typescript
+        let a: number = 1;
+    script(type='text/markdown').
+      # Data
+    :markdown-it
+      # Title
+    script
+      :babel
+        render(<App />);
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST026 - Scalate filters",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				Scalate: true,
+			},
+			SourceHTML: `<p>scalate</p>
+<style>p { color: red; }</style>
+<script>var a = 1;</script>
+`,
+			ExpectedJade: `html
+  body
+    p scalate
+    :css
+      p { color: red; }
+    :javascript
+      var a = 1;
+`,
+			NilAssertion: assert.Nil,
+		},
 
-		t.Run(tc.Desc, func(t *testing.T) {
+		{
+			Desc: "TEST027 - Noscript, scripting disabled",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:  2,
+				Noscript: entities.DisableScriptingNoscriptMode,
+			},
+			SourceHTML: `<p>noscript</p>
+<noscript><img src="/pixel.gif" alt=""><p>Please enable JavaScript</p></noscript>
+`,
+			ExpectedJade: `html
+  body
+    p noscript
+    noscript
+      img(src='/pixel.gif', alt='')
+      p Please enable JavaScript
+`,
+			NilAssertion: assert.Nil,
+		},
 
-			jadeConvertor := pkg.NewHtml2PugConvertor(tc.Options)
-			// jadeConvertor.Convert(tc.SourceHTML, (*entities.IStringWriter)(&stringOutput), tc.Options)
+		{
+			Desc: "TEST028 - Noscript, fragment",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:  2,
+				Noscript: entities.FragmentNoscriptMode,
+			},
+			SourceHTML: `<p>noscript</p>
+<noscript><img src="/pixel.gif" alt=""><p>Please enable JavaScript</p></noscript>
+`,
+			ExpectedJade: `html
+  body
+    p noscript
+    noscript
+      img(src='/pixel.gif', alt='')
+      p Please enable JavaScript
+`,
+			NilAssertion: assert.Nil,
+		},
 
-			callback := func(err error, jadeOutput string) {
-				assert.Equal(t, tc.ExpectedJade, jadeOutput)
-			}
-			jadeConvertor.ConvertHTML(tc.SourceHTML, (entities.Html2JadeConvertorConvertDocumentCallback)(callback))
+		{
+			Desc: "TEST029 - XHTML",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:   2,
+				KeepHead:  true,
+				InputType: entities.XHTMLProgramInputType,
+			},
+			SourceHTML: `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head>
+<script type="text/javascript"><![CDATA[
+if (a < b) { go(); }
+]]></script>
+</head>
+<body>
+<div id="empty"/>
+<p>After the empty div</p>
+<div class="cdata"><![CDATA[<b>not markup</b>]]></div>
+</body>
+</html>
+`,
+			ExpectedJade: `doctype xml
+html(xmlns='http://www.w3.org/1999/xhtml')
+  head
+    script(type='text/javascript').
+      if (a < b) { go(); }
+  body
+    #empty
+    p After the empty div
+    .cdata.
+      <b>not markup</b>
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST030 - XHTML doctype",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:   2,
+				InputType: entities.XHTMLProgramInputType,
+			},
+			SourceHTML: `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html><body><p>strict</p></body></html>
+`,
+			ExpectedJade: `doctype strict
+html
+  body
+    p strict
+`,
+			NilAssertion: assert.Nil,
+		},
 
-		})
-	}
+		{
+			Desc: "TEST031 - Charset detection",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:       2,
+				DetectCharset: true,
+			},
+			SourceHTML: "<meta charset=\"windows-1252\"><p>caf\xe9 \x93quoted\x94</p>\n",
+			ExpectedJade: `html
+  body
+    p café “quoted”
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST032 - Charset from content type",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:       2,
+				DetectCharset: true,
+				ContentType:   "text/html; charset=shift_jis",
+			},
+			SourceHTML: "<p>\x93\xfa\x96\x7b</p>\n",
+			ExpectedJade: `html
+  body
+    p 日本
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST033 - Output encoding",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				OutputOptions: &entities.OutputOptions{
+					LineEnding:   entities.CRLFLineEnding,
+					BOM:          true,
+					FinalNewline: true,
+				},
+			},
+			SourceHTML:   "<p>one</p><pre>two</pre>",
+			ExpectedJade: "\ufeffhtml\r\n  body\r\n    p one\r\n    pre.\r\n      \\ntwo\r\n",
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc:    "TEST034 - Wide text stays inline",
+			Options: defaultOptions,
+			SourceHTML: `<p>日本語のテキストは表示幅で測られるので、この段落はタグの後に残ります</p>
+`,
+			ExpectedJade: `html
+  body
+    p 日本語のテキストは表示幅で測られるので、この段落はタグの後に残ります
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST035 - Inline text length",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					InlineTextLength: &inlineTextLength,
+				},
+			},
+			SourceHTML: `<p>short</p>
+<p>this text is longer than the threshold</p>
+`,
+			ExpectedJade: `html
+  body
+    p short
+    p
+      this text is longer than the threshold
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST036 - Text layout, pipe",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:    2,
+				TextLayout: entities.PipeTextLayout,
+			},
+			SourceHTML: `<p>short</p>
+<p>
+  Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt
+  ut labore et dolore magna aliqua.
+</p>
+<p>Here is a <a href="#">link</a> with <em>emphasis</em></p>
+`,
+			ExpectedJade: `html
+  body
+    p short
+    p
+      |   Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt
+      |   ut labore et dolore magna aliqua.
+    p
+      | Here is a 
+      a(href='#') link
+      |  with 
+      em emphasis
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST037 - Text layout, block",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:    2,
+				TextLayout: entities.BlockTextLayout,
+			},
+			SourceHTML: `<p>short</p>
+<p>
+  Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt
+  ut labore et dolore magna aliqua.
+</p>
+<p>Here is a <a href="#">link</a> with <em>emphasis</em></p>
+`,
+			ExpectedJade: `html
+  body
+    p short
+    p.
+      Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor
+      incididunt ut labore et dolore magna aliqua.
+    p.
+      Here is a <a href="#">link</a> with <em>emphasis</em>
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST038 - Text layout, auto",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:    2,
+				TextLayout: entities.AutoTextLayout,
+			},
+			SourceHTML: `<p>short</p>
+<p>
+  Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt
+  ut labore et dolore magna aliqua.
+</p>
+<p>Here is a <a href="#">link</a> with <em>emphasis</em></p>
+`,
+			ExpectedJade: `html
+  body
+    p short
+    p.
+      Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor
+      incididunt ut labore et dolore magna aliqua.
+    p
+      | Here is a 
+      a(href='#') link
+      |  with 
+      em emphasis
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST039 - Wrap attributes",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					WrapAttributes: &wrapAttributes,
+				},
+			},
+			SourceHTML: `<form action="/subscribe" method="post">
+<input type="email" name="email" id="signup-email" class="form-control" placeholder="you@example.com" autocomplete="email">
+<input type="submit" value="Go">
+</form>
+`,
+			ExpectedJade: `html
+  body
+    form(action='/subscribe', method='post')
+      input#signup-email.form-control(
+        type='email',
+        name='email',
+        placeholder='you@example.com',
+        autocomplete='email'
+      )
+      input(type='submit', value='Go')
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST040 - Wrap attributes, no comma",
+			Options: &entities.Html2JadeConvertorOptions{
+				UseTabs: true,
+				WriterOptions: &entities.WriterOptions{
+					WrapAttributes: &wrapAttributes,
+					NoAttrComma:    &noAttrComma,
+				},
+			},
+			SourceHTML: `<button type="submit" class="btn btn-primary" data-loading-text="Sending your request..." data-toggle="button">Send</button>
+`,
+			ExpectedJade: "html\n\tbody\n\t\tbutton.btn.btn-primary(\n\t\t\ttype='submit'\n\t\t\tdata-loading-text='Sending your request...'\n\t\t\tdata-toggle='button'\n\t\t) Send\n",
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST041 - Attributes, template literals",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					TemplateLiterals: &templateLiterals,
+				},
+			},
+			SourceHTML: "<img src=\"img/close_button.png\" alt=\"Home\"\n" +
+				"onclick=\"\n" +
+				"    mwl.switchClass('#search_title', &quot;show&quot;);\n" +
+				"    mwl.log(`${count} items\\n`);\"/>\n",
+			ExpectedJade: "html\n" +
+				"  body\n" +
+				"    img(src='img/close_button.png', alt='Home', onclick=`\n" +
+				"    mwl.switchClass('#search_title', \"show\");\n" +
+				"    mwl.log(\\`\\${count} items\\\\n\\`);`)\n",
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc:    "TEST042 - Boolean attributes",
+			Options: defaultOptions,
+			SourceHTML: `<input type="checkbox" disabled="disabled" checked="" data-x="">
+<select multiple><option value="false" selected="false">No</option></select>
+<details open=""><summary hidden>s</summary></details>
+<div open="" checked=""></div>
+<svg><rect hidden=""></rect></svg>
+`,
+			ExpectedJade: `html
+  body
+    input(type='checkbox', disabled, checked, data-x='')
+    select(multiple)
+      option(value='false', selected='false') No
+    details(open)
+      summary(hidden) s
+    div(open='', checked='')
+    svg
+      rect(hidden='')
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST043 - Boolean attributes, explicit",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					ExplicitBooleanAttributes: &explicitBooleanAttributes,
+				},
+			},
+			SourceHTML: `<input type="checkbox" disabled="disabled" checked="">
+`,
+			ExpectedJade: `html
+  body
+    input(type='checkbox', disabled='disabled', checked='')
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST044 - Style objects",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					StyleObjects: &styleObjects,
+				},
+			},
+			SourceHTML: `<div style="color: red; margin-top:4px; background: url('a;b.png') no-repeat; --gap: 2px;">styled</div>
+<div style="color: red; /* comment */">commented</div>
+<div style="color">broken</div>
+<div style="display: flex; DISPLAY: grid">repeated</div>
+`,
+			ExpectedJade: `html
+  body
+    div(style={color: 'red', 'margin-top': '4px', background: 'url(\'a;b.png\') no-repeat', '--gap': '2px'}) styled
+    div(style='color: red; /* comment */') commented
+    div(style='color') broken
+    div(style='display: flex; DISPLAY: grid') repeated
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST045 - Data objects",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					DataObjects: &dataObjects,
+				},
+			},
+			SourceHTML: `<div data-config='{"zoom": 3, "center": [51.5, -0.12], "label": "Joe&#39;s", "data-id": null, "live": true, "__proto__": {"x": 1}}' data-items='["a", "b"]' data-name="{not json}" title='{"a": 1}'>map</div>
+`,
+			ExpectedJade: `html
+  body
+    div(data-config={zoom: 3, center: [51.5, -0.12], label: 'Joe\'s', 'data-id': null, live: true, ['__proto__']: {x: 1}}, data-items=['a', 'b'], data-name='{not json}', title='{"a": 1}') map
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST046 - Class mode, split",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					ClassMode: entities.SplitClassMode,
+				},
+			},
+			SourceHTML: `<div class="md:flex w-1/2 p-4">a</div>
+<div class="p-4 md:flex w-1/2">b</div>
+<div class="p-4 m-2">c</div>
+`,
+			ExpectedJade: `html
+  body
+    .p-4(class='md:flex w-1/2') a
+    .p-4(class='md:flex w-1/2') b
+    .p-4.m-2 c
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST047 - Class mode, array",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					ClassMode: entities.ArrayClassMode,
+				},
+			},
+			SourceHTML: `<div class="md:flex w-1/2 p-4">a</div>
+<div class="p-4 md:flex w-1/2">b</div>
+<div class="p-4 m-2">c</div>
+`,
+			ExpectedJade: `html
+  body
+    div(class=['md:flex', 'w-1/2', 'p-4']) a
+    div(class=['p-4', 'md:flex', 'w-1/2']) b
+    .p-4.m-2 c
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc: "TEST048 - Class mode, tailwind",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces: 2,
+				WriterOptions: &entities.WriterOptions{
+					ClassMode: entities.TailwindClassMode,
+				},
+			},
+			SourceHTML: `<div class="md:flex w-1/2 p-4">a</div>
+<div class="p-4 md:flex w-1/2">b</div>
+<div class="p-4 m-2">c</div>
+`,
+			ExpectedJade: `html
+  body
+    div(class=['md:flex', 'w-1/2', 'p-4']) a
+    .p-4(class=['md:flex', 'w-1/2']) b
+    .p-4.m-2 c
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc:    "TEST049 - Shorthand identifiers",
+			Options: defaultOptions,
+			SourceHTML: `<div id="1st" class="2col 123 -">a</div>
+<div id=" padded" class="_ -x">b</div>
+`,
+			ExpectedJade: `html
+  body
+    #1st.2col(class='123 -') a
+    ._.-x(id=' padded') b
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc:    "TEST050 - Interpolated tag names",
+			Options: defaultOptions,
+			SourceHTML: `<p>tags</p>
+<x.y class="a">dot</x.y>
+<my-widget->trailing hyphen</my-widget->
+<svg><linearGradient id="g"></linearGradient></svg>
+`,
+			ExpectedJade: `html
+  body
+    p tags
+    #{'x.y'}.a dot
+    #{'my-widget-'} trailing hyphen
+    svg
+      linearGradient#g
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST051 - Namespaced tag names",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:   2,
+				InputType: entities.XHTMLProgramInputType,
+			},
+			SourceHTML: `<html xmlns:h="http://xmlns.jcp.org/jsf/html" xmlns:o="urn:schemas-microsoft-com:office:office">
+<body>
+<h:inputText value="#{bean.name}"/>
+<o:p></o:p>
+</body>
+</html>
+`,
+			ExpectedJade: `html(xmlns:h='http://xmlns.jcp.org/jsf/html', xmlns:o='urn:schemas-microsoft-com:office:office')
+  body
+    h:inputText(value='#{bean.name}')
+    o:p
+`,
+			NilAssertion: assert.Nil,
+		},
+
+		{
+			Desc:    "TEST052 - Raw HTML fallback",
+			Options: defaultOptions,
+			SourceHTML: "<p>Total: #{price} and #[b] !{raw}</p>\n" +
+				"<button (click)=\"go()\" [disabled]=\"!ok\">Go</button>\n" +
+				"<span a'b\"c=\"1\">quotes</span>\n" +
+				"<div a'b\"c=\"1\">one\ntwo</div>\n",
+			ExpectedJade: `html
+  body
+    p Total: \#{price} and \#[b] \!{raw}
+    button('(click)'='go()', '[disabled]'='!ok') Go
+    <span a'b"c="1">quotes</span>
+    | <div a'b"c="1">one
+    | two</div>
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST053 - Tag handlers",
+			Options: tagHandlerOptions,
+			SourceHTML: `<iframe src="/map"></iframe>
+<video controls><source src="a.mp4"></video>
+<my-card title="t"><p>body</p></my-card>
+`,
+			ExpectedJade: `html
+  body
+    +embed(src='/map')
+    //- video
+    video(controls)
+      source(src='a.mp4')
+    +component('my-card')(title='t')
+      p body
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST054 - Transforms",
+			Options: transformOptions,
+			SourceHTML: `<div class="wrapper"><div class="wrapper"><p><b>Hi</b> <a href="http://x.io" target="_blank">x</a></p></div></div>
+<img src="/t.gif" width="1" height="1"><img src="/a.png" width="10" height="1">
+`,
+			ExpectedJade: `p.lead
+  strong Hi
+  a(href='https://x.io') x
+img(src='/a.png', width='10', height='1')
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST055 - Selected subtrees",
+			Options: selectOptions,
+			SourceHTML: `<!DOCTYPE html><header><div class="card">nav</div></header>
+<main><h1>Title</h1><div class="card">in main</div></main>
+<div id="app"><p>app</p></div>
+`,
+			ExpectedJade: `.card nav
+main
+  h1 Title
+  .card in main
+#app
+  p app
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST056 - Selected subtrees in a block",
+			Options: selectBlockOptions,
+			SourceHTML: `<header>nav</header>
+<div id="app"><p>app</p></div>
+`,
+			ExpectedJade: `block content
+  #app
+    p app
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST057 - Interpolation in verbatim text",
+			Options: defaultOptions,
+			SourceHTML: "<div><script>var s = \"#{x}\" + \"#[y\";</script>" +
+				"<style>a:after{content:\"!{z}\"}</style></div>\n",
+			ExpectedJade: `html
+  body
+    div
+      script.
+        var s = "\#{x}" + "\#[y";
+      style.
+        a:after{content:"\!{z}"}
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST058 - Raw HTML blank lines",
+			Options: defaultOptions,
+			SourceHTML: "<pre a'b\"c=\"1\">one\n\ntwo #{x}</pre>\n" +
+				"<textarea a'b\"c=\"1\">x\n\ny</textarea>\n",
+			ExpectedJade: `html
+  body
+    | <pre a'b"c="1">one
+    |
+    | two \#{x}</pre>
+    | <textarea a'b"c="1">x
+    |
+    | y</textarea>
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST059 - Tag handlers before the raw HTML fallback",
+			Options: rawHandlerOptions,
+			SourceHTML: "<div><span a'b\"c=\"1\">x</span></div>\n" +
+				"<p a'b\"c=\"1\">y</p>\n",
+			ExpectedJade: `div
+  //- span
+  <span a'b"c="1">x</span>
+//- p
+<p a'b"c="1">y</p>
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST060 - Conditional comments",
+			Options: defaultOptionsWithHead,
+			SourceHTML: `<head><!--[if lt IE 9]><script src="x.js"></script><![endif]--></head>` +
+				`<div><!--[if IE]> <p>ie</p> <![endif]--></div>` +
+				`<!--[if gt IE 8]><!--> <p>new</p> <!--<![endif]-->`,
+			ExpectedJade: `html
+  head
+    //if lt IE 9
+      script(src='x.js')
+  body
+    div
+      //if IE
+        p ie
+    // [if gt IE 8] <!
+    p new
+    // <![endif]
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc:    "TEST061 - Asset extraction leaves filtered languages",
+			Options: assetFilterOptions,
+			SourceHTML: `<div><style lang="scss">.a { .b { color: red; } }</style>
+<style lang="postcss">.a { @apply p-4; }</style>
+<script lang="ts">let a: number = 1;</script></div>
+`,
+			ExpectedJade: `div
+  style
+    :scss
+      .a { .b { color: red; } }
+  style(lang='postcss').
+    .a { @apply p-4; }
+  script
+    :typescript
+      let a: number = 1;
+`,
+			ExpectedFiles: map[string]string{},
+			NilAssertion:  assert.Nil,
+		},
+		{
+			Desc: "TEST062 - XHTML void elements without a closing slash",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:   2,
+				Bodyless:  true,
+				InputType: entities.XHTMLProgramInputType,
+			},
+			SourceHTML: `<div><img src="x.png"><span>after</span><br>text<br/><input type="text"></input>end</div>
+`,
+			ExpectedJade: `div
+  img(src='x.png')
+  span after
+  br
+  text
+  br
+  input(type='text')
+  end
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST063 - XHTML charset from the prolog",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:       2,
+				Bodyless:      true,
+				DetectCharset: true,
+				InputType:     entities.XHTMLProgramInputType,
+			},
+			SourceHTML: "<?xml version=\"1.0\" encoding=\"Shift_JIS\"?>\n<html><body><p>\x93\xfa\x96\x7b</p></body></html>\n",
+			ExpectedJade: `doctype xml
+p 日本
+`,
+			NilAssertion: assert.Nil,
+		},
+		{
+			Desc: "TEST064 - UTF-8 BOM with charset detection",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:       2,
+				Bodyless:      true,
+				DetectCharset: true,
+			},
+			SourceHTML: "\ufeff<p>café</p>\n",
+			ExpectedJade: `p café
+`,
+			NilAssertion: assert.Nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		logrus.Infoln("test case " + tc.Desc)
+
+		tc := tc
+		if tc.Skip {
+			logrus.Warnln("skipping " + tc.Desc)
+			// t.Skip(tc.Desc)
+			continue
+		}
+
+		t.Run(tc.Desc, func(t *testing.T) {
+
+			jadeConvertor := pkg.NewHtml2PugConvertor(tc.Options)
+			// jadeConvertor.Convert(tc.SourceHTML, (*entities.IStringWriter)(&stringOutput), tc.Options)
+
+			callback := func(err error, jadeOutput string) {
+				assert.Equal(t, tc.ExpectedJade, jadeOutput)
+			}
+			jadeConvertor.ConvertHTML(tc.SourceHTML, (entities.Html2JadeConvertorConvertDocumentCallback)(callback))
+
+			if tc.ExpectedFiles != nil {
+				var written []string
+				_ = filepath.WalkDir(tc.Options.OutDirectoryPath, func(filePath string, entry os.DirEntry, err error) error {
+					if err == nil && !entry.IsDir() {
+						relPath, _ := filepath.Rel(tc.Options.OutDirectoryPath, filePath)
+						written = append(written, filepath.ToSlash(relPath))
+					}
+					return err
+				})
+				expectedNames := make([]string, 0, len(tc.ExpectedFiles))
+				for name, content := range tc.ExpectedFiles {
+					expectedNames = append(expectedNames, name)
+					data, err := os.ReadFile(filepath.Join(tc.Options.OutDirectoryPath, filepath.FromSlash(name)))
+					assert.NoError(t, err)
+					assert.Equal(t, content, string(data))
+				}
+				assert.ElementsMatch(t, expectedNames, written)
+			}
+		})
+	}
+}
+
+func TestDocType(t *testing.T) {
+
+	type TestCase struct {
+		Desc         string
+		SourceHTML   string
+		ExpectedJade string
+	}
+
+	testCases := []TestCase{
+		{
+			Desc:         "DOCTYPE000 - HTML5",
+			SourceHTML:   `<!DOCTYPE html><p>a</p>`,
+			ExpectedJade: "doctype html\np a\n",
+		},
+		{
+			Desc:         "DOCTYPE001 - XHTML public identifier",
+			SourceHTML:   `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"><p>a</p>`,
+			ExpectedJade: "doctype strict\np a\n",
+		},
+		{
+			Desc:         "DOCTYPE002 - Unknown public identifier",
+			SourceHTML:   `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01//EN"><p>a</p>`,
+			ExpectedJade: "p a\n",
+		},
+		{
+			Desc:         "DOCTYPE003 - No doctype",
+			SourceHTML:   `<p>a</p>`,
+			ExpectedJade: "p a\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Desc, func(t *testing.T) {
+			jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2, Bodyless: true})
+			jadeConvertor.ConvertHTML(tc.SourceHTML, func(err error, jadeOutput string) {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.ExpectedJade, jadeOutput)
+			})
+		})
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	type TestCase struct {
+		Desc                string
+		Options             *entities.Html2JadeConvertorOptions
+		SourceHTML          string
+		ExpectedDiagnostics []entities.Diagnostic
+	}
+
+	testCases := []TestCase{
+		{
+			Desc:                "DIAG000 - Clean conversion",
+			Options:             &entities.Html2JadeConvertorOptions{NSpaces: 2},
+			SourceHTML:          `<p class="a b">hello</p>`,
+			ExpectedDiagnostics: nil,
+		},
+		{
+			Desc:       "DIAG001 - Lossy conversions",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2},
+			SourceHTML: "<head><title>t</title></head>\n<div class=\"a b:c\">\n  <pre>a<b>b</b></pre>\n<!--[if IE]><p>ie</p><![endif]-->\n</div>",
+			ExpectedDiagnostics: []entities.Diagnostic{
+				{
+					Severity: entities.InfoDiagnosticSeverity,
+					Code:     entities.HeadDiagnosticCode,
+					Message:  "head content discarded, set KeepHead to keep it",
+					Position: entities.Position{Offset: 0, Line: 1, Column: 1},
+					Path:     "/html[1]/head[1]",
+				},
+				{
+					Severity: entities.InfoDiagnosticSeverity,
+					Code:     entities.ClassAttributeDiagnosticCode,
+					Message:  `class names "b:c" are not valid shorthand, written as a class attribute after the others`,
+					Position: entities.Position{Offset: 30, Line: 2, Column: 1},
+					Path:     "/html[1]/body[1]/div[1]",
+				},
+				{
+					Severity: entities.WarningDiagnosticSeverity,
+					Code:     entities.PreChildrenDiagnosticCode,
+					Message:  "<b> inside <pre> dropped, only its text is kept",
+					Position: entities.Position{Offset: 58, Line: 3, Column: 9},
+					Path:     "/html[1]/body[1]/div[1]/pre[1]/b[1]",
+				},
+				{
+					Severity: entities.InfoDiagnosticSeverity,
+					Code:     entities.ConditionalDiagnosticCode,
+					Message:  "conditional comment [if IE] rewritten as a Pug comment",
+					Position: entities.Position{Offset: 73, Line: 4, Column: 1},
+					Path:     "/html[1]/body[1]/div[1]/comment()",
+				},
+			},
+		},
+		{
+			Desc:       "DIAG002 - XHTML positions",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2, InputType: entities.XHTMLProgramInputType},
+			SourceHTML: "<html>\n<body>\n  <my.tag/>\n</body>\n</html>",
+			ExpectedDiagnostics: []entities.Diagnostic{
+				{
+					Severity: entities.InfoDiagnosticSeverity,
+					Code:     entities.TagInterpolationDiagnosticCode,
+					Message:  `tag name "my.tag" written with #{} interpolation`,
+					Position: entities.Position{Offset: 16, Line: 3, Column: 3},
+					Path:     "/html[1]/body[1]/my.tag[1]",
+				},
+			},
+		},
+		{
+			Desc:       "DIAG003 - Nothing selected",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2, Select: "main"},
+			SourceHTML: `<p>a</p>`,
+			ExpectedDiagnostics: []entities.Diagnostic{
+				{
+					Severity: entities.WarningDiagnosticSeverity,
+					Code:     entities.SelectDiagnosticCode,
+					Message:  `no element matches "main"`,
+					Path:     "/",
+				},
+			},
+		},
+		{
+			Desc:       "DIAG004 - Interpolation in attributes",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2, Bodyless: true},
+			SourceHTML: `<a title="#{t}">x</a>`,
+			ExpectedDiagnostics: []entities.Diagnostic{
+				{
+					Severity: entities.InfoDiagnosticSeverity,
+					Code:     entities.AttributeInterpolationDiagnosticCode,
+					Message:  `attribute "title" value "#{t}" written literally, Pug does not interpolate attribute values`,
+					Position: entities.Position{Offset: 0, Line: 1, Column: 1},
+					Path:     "/html[1]/body[1]/a[1]",
+				},
+			},
+		},
+		{
+			Desc:       "DIAG005 - Decoded XHTML positions",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2, InputType: entities.XHTMLProgramInputType, DetectCharset: true},
+			SourceHTML: "<?xml version=\"1.0\" encoding=\"Shift_JIS\"?>\n<html><body><p>\x93\xfa\x96\x7b</p>\n  <my.tag/></body></html>",
+			ExpectedDiagnostics: []entities.Diagnostic{
+				{
+					Severity: entities.InfoDiagnosticSeverity,
+					Code:     entities.TagInterpolationDiagnosticCode,
+					Message:  `tag name "my.tag" written with #{} interpolation`,
+					Position: entities.Position{Offset: 71, Line: 3, Column: 3},
+					Path:     "/html[1]/body[1]/my.tag[1]",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Desc, func(t *testing.T) {
+			jadeConvertor := pkg.NewHtml2PugConvertor(tc.Options)
+			jadeConvertor.ConvertHTMLWithDiagnostics(tc.SourceHTML, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.ExpectedDiagnostics, diagnostics)
+			})
+		})
+	}
+}
+
+func TestConcurrentConversions(t *testing.T) {
+	jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2, Bodyless: true})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			jadeConvertor.ConvertHTMLWithDiagnostics(`<p class="b:c">x</p>`, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+				assert.NoError(t, err)
+				assert.Equal(t, "p(class='b:c') x\n", jadeOutput)
+				if assert.Len(t, diagnostics, 1) {
+					assert.Equal(t, "/html[1]/body[1]/p[1]", diagnostics[0].Path)
+				}
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			jadeConvertor.ConvertHTMLWithDiagnostics(`<div><span id="a.b">y</span></div>`, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+				assert.NoError(t, err)
+				assert.Equal(t, "div\n  span(id='a.b') y\n", jadeOutput)
+				if assert.Len(t, diagnostics, 1) {
+					assert.Equal(t, "/html[1]/body[1]/div[1]/span[1]", diagnostics[0].Path)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInvalidSelect(t *testing.T) {
+	jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2, Select: "a["})
+	for i := 0; i < 2; i++ {
+		jadeConvertor.ConvertHTMLWithDiagnostics(`<a>x</a>`, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.Error(t, err)
+			assert.Empty(t, jadeOutput)
+			assert.Empty(t, diagnostics)
+		})
+	}
+}
+
+// wrappedConvertor is a custom Converter delegating to the built-in one.
+type wrappedConvertor struct {
+	entities.IConvertor
+}
+
+func TestSelectCustomConverter(t *testing.T) {
+	convertor := entities.IConvertor(wrappedConvertor{pkg.NewConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2})})
+	jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2, Select: "a", Converter: &convertor})
+	jadeConvertor.ConvertHTMLWithDiagnostics(`<p><a>x</a></p>`, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+		assert.Error(t, err)
+		assert.Empty(t, jadeOutput)
+	})
+}
+
+func TestAssetErrors(t *testing.T) {
+
+	extractAssets := &entities.AssetOptions{Scripts: true}
+
+	t.Run("ASSET000 - No output directory", func(t *testing.T) {
+		jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2, ExtractAssets: extractAssets})
+		jadeConvertor.ConvertHTML(`<script>var a = 1;</script>`, func(err error, jadeOutput string) {
+			assert.EqualError(t, err, "asset extraction requires OutDirectoryPath")
+			assert.Equal(t, "", jadeOutput)
+		})
+	})
+
+	t.Run("ASSET001 - Unwritable output directory", func(t *testing.T) {
+		// A file where the assets directory should be
+		outDirectoryPath := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(outDirectoryPath, "assets"), nil, 0o644))
+
+		jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{NSpaces: 2, Bodyless: true, OutDirectoryPath: outDirectoryPath, ExtractAssets: extractAssets})
+		jadeConvertor.ConvertHTMLWithDiagnostics(`<div><script>var a = 1;</script></div>`, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.NoError(t, err)
+			assert.Equal(t, "div\n  script.\n    var a = 1;\n", jadeOutput)
+			if assert.Len(t, diagnostics, 1) {
+				assert.Equal(t, entities.AssetDiagnosticCode, diagnostics[0].Code)
+			}
+		})
+	})
+}
+
+func TestSourceMap(t *testing.T) {
+
+	type TestCase struct {
+		Desc              string
+		ConvertorOptions  *entities.Html2JadeConvertorOptions
+		Options           entities.SourceMapOptions
+		SourceHTML        string
+		ExpectedJade      string
+		ExpectedSourceMap *entities.SourceMap
+	}
+
+	testCases := []TestCase{
+		{
+			Desc:       "SMAP000 - Lines map to their elements",
+			Options:    entities.SourceMapOptions{File: "page.pug", Source: "page.html"},
+			SourceHTML: "<div>\n                  <p>a</p>\n  <!-- c -->\n</div>\n<p>b</p>",
+			ExpectedJade: `html
+  body
+    div
+      p a
+      // c
+    p b
+`,
+			ExpectedSourceMap: &entities.SourceMap{
+				Version:  3,
+				File:     "page.pug",
+				Sources:  []string{"page.html"},
+				Names:    []string{},
+				Mappings: ";;AAAA;AACkB;AAChB;AAEF",
+			},
+		},
+		{
+			Desc:       "SMAP001 - Sources content",
+			Options:    entities.SourceMapOptions{Source: "page.html", SourcesContent: true},
+			SourceHTML: "<p>a</p>",
+			ExpectedJade: `html
+  body
+    p a
+`,
+			ExpectedSourceMap: &entities.SourceMap{
+				Version:        3,
+				Sources:        []string{"page.html"},
+				SourcesContent: []string{"<p>a</p>"},
+				Names:          []string{},
+				Mappings:       ";;AAAA",
+			},
+		},
+		{
+			Desc: "SMAP002 - UTF-16 columns after a BOM",
+			ConvertorOptions: &entities.Html2JadeConvertorOptions{
+				NSpaces:       2,
+				Bodyless:      true,
+				OutputOptions: &entities.OutputOptions{BOM: true},
+			},
+			Options:      entities.SourceMapOptions{Source: "page.html"},
+			SourceHTML:   "<p>é😀</p><p>b</p>",
+			ExpectedJade: "\ufeffp é😀\np b\n",
+			ExpectedSourceMap: &entities.SourceMap{
+				Version:  3,
+				Sources:  []string{"page.html"},
+				Names:    []string{},
+				Mappings: "CAAA;AAAU",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Desc, func(t *testing.T) {
+			options := tc.ConvertorOptions
+			if options == nil {
+				options = &entities.Html2JadeConvertorOptions{NSpaces: 2}
+			}
+			jadeConvertor := pkg.NewHtml2PugConvertor(options)
+			jadeConvertor.ConvertHTMLWithSourceMap(tc.SourceHTML, tc.Options, func(err error, jadeOutput string, sourceMap *entities.SourceMap, diagnostics []entities.Diagnostic) {
+				assert.NoError(t, err)
+				assert.Empty(t, diagnostics)
+				assert.Equal(t, tc.ExpectedJade, jadeOutput)
+				assert.Equal(t, tc.ExpectedSourceMap, sourceMap)
+			})
+		})
+	}
+}
+
+type recordingTracer struct {
+	Events []entities.TraceEvent
+}
+
+func (rt *recordingTracer) Trace(event entities.TraceEvent) {
+	rt.Events = append(rt.Events, event)
+}
+
+func TestTrace(t *testing.T) {
+
+	noEmptyPipe := true
+
+	type TestCase struct {
+		Desc           string
+		Options        *entities.Html2JadeConvertorOptions
+		SourceHTML     string
+		ExpectedEvents []entities.TraceEvent
+	}
+
+	testCases := []TestCase{
+		{
+			Desc:       "TRACE000 - Element branches",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2, Bodyless: true},
+			SourceHTML: "<head><title>t</title></head><div id=\"m\" class=\"a b:c\"><p>hi</p><!-- c --><script>x()</script><pre>y</pre></div>",
+			ExpectedEvents: []entities.TraceEvent{
+				{Decision: entities.BodylessSkipTraceDecision, Node: "html", Path: "/html[1]"},
+				{Decision: entities.HeadSkipTraceDecision, Node: "head", Path: "/html[1]/head[1]", Position: entities.Position{Offset: 0, Line: 1, Column: 1}},
+				{Decision: entities.BodylessSkipTraceDecision, Node: "body", Path: "/html[1]/body[1]"},
+				{Decision: entities.ChildrenTraceDecision, Node: "div", Path: "/html[1]/body[1]/div[1]", Position: entities.Position{Offset: 29, Line: 1, Column: 30}, Shorthand: []string{"#m", ".a"}},
+				{Decision: entities.InlineTextTraceDecision, Node: "p", Path: "/html[1]/body[1]/div[1]/p[1]", Position: entities.Position{Offset: 55, Line: 1, Column: 56}},
+				{Decision: entities.CommentTraceDecision, Node: "#comment", Path: "/html[1]/body[1]/div[1]/comment()", Position: entities.Position{Offset: 64, Line: 1, Column: 65}},
+				{Decision: entities.ScriptTraceDecision, Node: "script", Path: "/html[1]/body[1]/div[1]/script[1]", Position: entities.Position{Offset: 74, Line: 1, Column: 75}},
+				{Decision: entities.PreTraceDecision, Node: "pre", Path: "/html[1]/body[1]/div[1]/pre[1]", Position: entities.Position{Offset: 94, Line: 1, Column: 95}},
+			},
+		},
+		{
+			Desc: "TRACE001 - Dropped empty pipes",
+			Options: &entities.Html2JadeConvertorOptions{
+				NSpaces:       2,
+				Bodyless:      true,
+				TextLayout:    entities.PipeTextLayout,
+				WriterOptions: &entities.WriterOptions{NoEmptyPipe: &noEmptyPipe},
+			},
+			SourceHTML: "<div>a\n   \nb<br></div>",
+			ExpectedEvents: []entities.TraceEvent{
+				{Decision: entities.BodylessSkipTraceDecision, Node: "html", Path: "/html[1]"},
+				{Decision: entities.HeadSkipTraceDecision, Node: "head", Path: "/html[1]/head[1]"},
+				{Decision: entities.BodylessSkipTraceDecision, Node: "body", Path: "/html[1]/body[1]"},
+				{Decision: entities.ChildrenTraceDecision, Node: "div", Path: "/html[1]/body[1]/div[1]", Position: entities.Position{Offset: 0, Line: 1, Column: 1}},
+				{Decision: entities.DropEmptyPipeTraceDecision, Node: "#text", Path: "/html[1]/body[1]/div[1]/text()", Text: "   "},
+				{Decision: entities.ChildrenTraceDecision, Node: "br", Path: "/html[1]/body[1]/div[1]/br[1]", Position: entities.Position{Offset: 12, Line: 3, Column: 2}},
+			},
+		},
+		{
+			Desc:       "TRACE002 - Trimmed comment lines",
+			Options:    &entities.Html2JadeConvertorOptions{NSpaces: 2, Bodyless: true},
+			SourceHTML: "<div><!--\n a -->x</div>",
+			ExpectedEvents: []entities.TraceEvent{
+				{Decision: entities.BodylessSkipTraceDecision, Node: "html", Path: "/html[1]"},
+				{Decision: entities.HeadSkipTraceDecision, Node: "head", Path: "/html[1]/head[1]"},
+				{Decision: entities.BodylessSkipTraceDecision, Node: "body", Path: "/html[1]/body[1]"},
+				{Decision: entities.ChildrenTraceDecision, Node: "div", Path: "/html[1]/body[1]/div[1]", Position: entities.Position{Offset: 0, Line: 1, Column: 1}},
+				{Decision: entities.CommentTraceDecision, Node: "#comment", Path: "/html[1]/body[1]/div[1]/comment()", Position: entities.Position{Offset: 5, Line: 1, Column: 6}},
+				{Decision: entities.TrimTextTraceDecision, Node: "#comment", Path: "/html[1]/body[1]/div[1]/comment()", Position: entities.Position{Offset: 5, Line: 1, Column: 6}, Text: "\n a "},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Desc, func(t *testing.T) {
+			tracer := &recordingTracer{}
+			iTracer := entities.ITracer(tracer)
+			tc.Options.Tracer = &iTracer
+
+			jadeConvertor := pkg.NewHtml2PugConvertor(tc.Options)
+			jadeConvertor.ConvertHTML(tc.SourceHTML, func(err error, jadeOutput string) {
+				assert.NoError(t, err)
+			})
+			assert.Equal(t, tc.ExpectedEvents, tracer.Events)
+		})
+	}
+}
+
+func TestTransformRules(t *testing.T) {
+
+	type TestCase struct {
+		Desc         string
+		Rules        string
+		SourceHTML   string
+		ExpectedJade string
+		Error        bool
+	}
+
+	testCases := []TestCase{
+		{
+			Desc: "RULES000 - Actions",
+			Rules: `rules:
+  - select: script[src*=analytics]
+    remove: true
+  - select: "[ng-bind]"
+    rename-attr: {ng-bind: v-text}
+  - select: "*"
+    remove-class: [js-*]
+  - select: div.wrapper
+    unwrap: true
+  - select: b
+    rename: strong
+  - select: a[href^="http:"]
+    set-attr: {rel: noopener}
+    remove-attr: [data-track-*]
+    add-class: [external, link]
+`,
+			SourceHTML: `<script src="https://x/analytics.js"></script><div class="wrapper js-init"><p ng-bind="msg" class="js-x lead">a<b>b</b></p><a href="http://x" data-track-id="1" data-track-ev="c" class="link">x</a></div>`,
+			ExpectedJade: `p.lead(v-text='msg')
+  a
+  strong b
+a.link.external(href='http://x', rel='noopener') x
+`,
+		},
+		{
+			Desc:       "RULES001 - Empty file",
+			Rules:      ``,
+			SourceHTML: `<p>a</p>`,
+			ExpectedJade: `p a
+`,
+		},
+		{
+			Desc: "RULES002 - Unknown action",
+			Rules: `rules:
+  - select: a
+    delete: true
+`,
+			Error: true,
+		},
+		{
+			Desc: "RULES003 - Invalid selector",
+			Rules: `rules:
+  - select: "a["
+    remove: true
+`,
+			Error: true,
+		},
+		{
+			Desc: "RULES004 - Missing selector",
+			Rules: `rules:
+  - remove: true
+`,
+			Error: true,
+		},
+		{
+			Desc: "RULES005 - Invalid pattern",
+			Rules: `rules:
+  - select: a
+    remove-class: ["js-["]
+`,
+			Error: true,
+		},
+		{
+			Desc: "RULES006 - Patterns with slashes",
+			Rules: `rules:
+  - select: div
+    remove-class: [w-*]
+`,
+			SourceHTML: `<div class="w-1/2 md:w-1/3 w-full p-4">a</div>`,
+			ExpectedJade: `.p-4(class='md:w-1/3') a
+`,
+		},
+		{
+			Desc: "RULES007 - Renaming onto an existing attribute",
+			Rules: `rules:
+  - select: p
+    rename-attr: {ng-bind: v-text}
+`,
+			SourceHTML: `<p v-text="old" ng-bind="msg" id="x">a</p>`,
+			ExpectedJade: `p#x(v-text='msg') a
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Desc, func(t *testing.T) {
+			rules, err := pkg.ParseTransformRules([]byte(tc.Rules))
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			jadeConvertor := pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{
+				NSpaces:  2,
+				Bodyless: true,
+				Rules:    rules,
+			})
+			jadeConvertor.ConvertHTML(tc.SourceHTML, func(err error, jadeOutput string) {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.ExpectedJade, jadeOutput)
+			})
+		})
+	}
+}
+
+func TestQuerySelector(t *testing.T) {
+
+	root, err := html.Parse(strings.NewReader(`<main id="main">
+<ul id="list"><li id="l1">1</li><li id="l2" class="on">2</li><li id="l3">3</li><li id="l4">4</li><li id="l5">5</li></ul>
+<section id="s1"><h2 id="h1">A</h2><p id="p1">a</p><p id="p2">b</p><span id="sp1"></span><p id="p3">c</p></section>
+<section id="s2"><p id="p4"><em id="em1">d</em></p></section>
+</main>`))
+	assert.NoError(t, err)
+	document := &entities.Document{Root: root}
+
+	type TestCase struct {
+		Selector    string
+		ExpectedIds []string
+		Error       bool
+	}
+
+	testCases := []TestCase{
+		{Selector: "main p", ExpectedIds: []string{"p1", "p2", "p3", "p4"}},
+		{Selector: "section > p", ExpectedIds: []string{"p1", "p2", "p3", "p4"}},
+		{Selector: "main > p", ExpectedIds: nil},
+		{Selector: "main em", ExpectedIds: []string{"em1"}},
+		{Selector: "section>p>em", ExpectedIds: []string{"em1"}},
+		{Selector: "h2 + p", ExpectedIds: []string{"p1"}},
+		{Selector: "h2 ~ p", ExpectedIds: []string{"p1", "p2", "p3"}},
+		{Selector: "span + p", ExpectedIds: []string{"p3"}},
+		{Selector: "li:not(.on)", ExpectedIds: []string{"l1", "l3", "l4", "l5"}},
+		{Selector: "li:not(.on, :first-child)", ExpectedIds: []string{"l3", "l4", "l5"}},
+		{Selector: "p:not(section#s1 > p)", ExpectedIds: []string{"p4"}},
+		{Selector: "li:nth-child(2)", ExpectedIds: []string{"l2"}},
+		{Selector: "li:nth-child(odd)", ExpectedIds: []string{"l1", "l3", "l5"}},
+		{Selector: "li:nth-child(even)", ExpectedIds: []string{"l2", "l4"}},
+		{Selector: "li:nth-child(3n+1)", ExpectedIds: []string{"l1", "l4"}},
+		{Selector: "li:nth-child(-n+2)", ExpectedIds: []string{"l1", "l2"}},
+		{Selector: "li:nth-child(n + 4)", ExpectedIds: []string{"l4", "l5"}},
+		{Selector: "li:nth-last-child(1)", ExpectedIds: []string{"l5"}},
+		{Selector: "li:first-child, li:last-child", ExpectedIds: []string{"l1", "l5"}},
+		{Selector: "#s1 > :first-of-type", ExpectedIds: []string{"h1", "p1", "sp1"}},
+		{Selector: "#s1 > p:last-of-type", ExpectedIds: []string{"p3"}},
+		{Selector: "#s1 > p:nth-of-type(2)", ExpectedIds: []string{"p2"}},
+		{Selector: "p:only-child", ExpectedIds: []string{"p4"}},
+		{Selector: "#s1 > :only-of-type", ExpectedIds: []string{"h1", "sp1"}},
+		{Selector: "span:empty", ExpectedIds: []string{"sp1"}},
+		{Selector: ":root", ExpectedIds: []string{""}},
+		{Selector: "li:hover", Error: true},
+		{Selector: "li:nth-child(x)", Error: true},
+		{Selector: "li:not(", Error: true},
+		{Selector: "main >", Error: true},
+		{Selector: "li)", Error: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Selector, func(t *testing.T) {
+			nodes, err := document.QuerySelectorAll(tc.Selector)
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			var ids []string
+			for _, node := range nodes {
+				ids = append(ids, util.GetAttr(node, "id"))
+			}
+			assert.Equal(t, tc.ExpectedIds, ids)
+
+			first, err := document.QuerySelector(tc.Selector)
+			assert.NoError(t, err)
+			if len(nodes) > 0 {
+				assert.Equal(t, nodes[0], first)
+			} else {
+				assert.Nil(t, first)
+			}
+
+			selector, err := util.CompileSelector(tc.Selector)
+			assert.NoError(t, err)
+			assert.Equal(t, nodes, document.Select(selector))
+		})
+	}
+}
+
+func TestConvertNode(t *testing.T) {
+
+	sourceHTML := `<!DOCTYPE html><html><head><title>T</title></head><body><div class="wrapper"><!--[if IE]> <p>ie</p> <![endif]--><b>a</b>b<img src="/t.gif" width="1" height="1"></div></body></html>`
+
+	parse := func(t *testing.T) *html.Node {
+		root, err := html.Parse(strings.NewReader(sourceHTML))
+		assert.NoError(t, err)
+		return root
+	}
+	options := func() *entities.Html2JadeConvertorOptions {
+		return &entities.Html2JadeConvertorOptions{
+			NSpaces:  2,
+			Bodyless: true,
+			Rules: []entities.TransformRule{
+				{Select: "div.wrapper", Unwrap: true},
+				{Select: "img[width='1']", Remove: true},
+				{Select: "b", Rename: "strong"},
+			},
+		}
+	}
+	render := func(t *testing.T, nodes ...*html.Node) string {
+		var builder strings.Builder
+		for _, node := range nodes {
+			assert.NoError(t, html.Render(&builder, node))
+		}
+		return builder.String()
+	}
+
+	t.Run("CONVNODE000 - Document node", func(t *testing.T) {
+		root := parse(t)
+		before := render(t, root)
+
+		pkg.NewHtml2PugConvertor(options()).ConvertNode(root, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.NoError(t, err)
+			assert.Equal(t, "doctype html\n//if IE\n  p ie\nstrong a\n| b\n", jadeOutput)
+		})
+		assert.Equal(t, before, render(t, root))
+	})
+
+	t.Run("CONVNODE001 - Element node", func(t *testing.T) {
+		root := parse(t)
+		before := render(t, root)
+		document := &entities.Document{Root: root}
+		div, err := document.QuerySelector("div")
+		assert.NoError(t, err)
+
+		pkg.NewHtml2PugConvertor(options()).ConvertNode(div, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.NoError(t, err)
+			assert.Equal(t, "//if IE\n  p ie\nstrong a\n| b\n", jadeOutput)
+		})
+		assert.Equal(t, before, render(t, root))
+	})
+
+	t.Run("CONVNODE002 - Fragment", func(t *testing.T) {
+		context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		nodes, err := html.ParseFragment(strings.NewReader(`<li>one</li><li class="wrapper">two</li>text`), context)
+		assert.NoError(t, err)
+		before := render(t, nodes...)
+
+		pkg.NewHtml2PugConvertor(options()).ConvertNodes(nodes, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.NoError(t, err)
+			assert.Equal(t, "li one\nli.wrapper two\n| text\n", jadeOutput)
+		})
+		assert.Equal(t, before, render(t, nodes...))
+	})
+
+	t.Run("CONVNODE003 - Document with positions", func(t *testing.T) {
+		parser := pkg.NewParser(&entities.Html2JadeConvertorOptions{})
+		var document *entities.Document
+		parser.Parse(strings.NewReader(`<div>
+  <p class="a b:c">one</p></div>`), func(errs []error, window entities.Window) {
+			document = window.Document
+		})
+		// Positions are only located once a conversion reports them
+		assert.Nil(t, document.Positions)
+		before := render(t, document.Root)
+
+		pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{Bodyless: true}).ConvertDocument(document, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.NoError(t, err)
+			assert.Equal(t, "div\n  p.a(class='b:c') one\n", jadeOutput)
+			if assert.Len(t, diagnostics, 1) {
+				assert.Equal(t, entities.Position{Offset: 8, Line: 2, Column: 3}, diagnostics[0].Position)
+			}
+		})
+		assert.Equal(t, before, render(t, document.Root))
+	})
+
+	t.Run("CONVNODE004 - Missing document", func(t *testing.T) {
+		pkg.NewHtml2PugConvertor(&entities.Html2JadeConvertorOptions{}).ConvertDocument(nil, func(err error, jadeOutput string, diagnostics []entities.Diagnostic) {
+			assert.Error(t, err)
+		})
+	})
 }
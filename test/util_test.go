@@ -0,0 +1,347 @@
+package pkg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chrisbward/html2pug-go/pkg/html2pug-go/util"
+	assert "github.com/stretchr/testify/assert"
+	html "golang.org/x/net/html"
+)
+
+func TestDisplayWidth(t *testing.T) {
+
+	type TestCase struct {
+		Desc     string
+		Text     string
+		Expected int
+	}
+
+	testCases := []TestCase{
+		{Desc: "ASCII", Text: "hello world", Expected: 11},
+		{Desc: "Precomposed accent", Text: "café", Expected: 4},
+		{Desc: "Combining accent", Text: "cafe\u0301", Expected: 4},
+		{Desc: "CJK", Text: "日本語", Expected: 6},
+		{Desc: "Fullwidth", Text: "ＡＢ", Expected: 4},
+		{Desc: "Hangul", Text: "한국어", Expected: 6},
+		{Desc: "Emoji", Text: "ok 😀", Expected: 5},
+		{Desc: "Emoji ZWJ sequence", Text: "\U0001f468\u200d\U0001f469\u200d\U0001f467", Expected: 2},
+		{Desc: "Emoji skin tone", Text: "\U0001f44d\U0001f3fd", Expected: 2},
+		{Desc: "Emoji presentation selector", Text: "\u2764\ufe0f", Expected: 2},
+		{Desc: "Flag", Text: "🇯🇵🇫🇷", Expected: 4},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Desc, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, util.DisplayWidth(tc.Text))
+		})
+	}
+}
+
+func TestIsValidJadeId(t *testing.T) {
+
+	type TestCase struct {
+		Id       string
+		Expected bool
+	}
+
+	testCases := []TestCase{
+		{Id: "main", Expected: true},
+		{Id: "main-content", Expected: true},
+		{Id: "main_content", Expected: true},
+		{Id: "MainContent", Expected: true},
+		{Id: "1st", Expected: true},
+		{Id: "123", Expected: true},
+		{Id: "-", Expected: true},
+		{Id: "_", Expected: true},
+		{Id: "", Expected: false},
+		{Id: " main", Expected: false},
+		{Id: "main ", Expected: false},
+		{Id: "a b", Expected: false},
+		{Id: "a.b", Expected: false},
+		{Id: "a:b", Expected: false},
+		{Id: "a/b", Expected: false},
+		{Id: "a#b", Expected: false},
+		{Id: "{x}", Expected: false},
+		{Id: "café", Expected: false},
+		{Id: "日本", Expected: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Id, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, util.IsValidJadeId(tc.Id))
+		})
+	}
+}
+
+func TestIsValidJadeClassName(t *testing.T) {
+
+	type TestCase struct {
+		ClassName string
+		Expected  bool
+	}
+
+	testCases := []TestCase{
+		{ClassName: "btn", Expected: true},
+		{ClassName: "btn-primary", Expected: true},
+		{ClassName: "btn_primary", Expected: true},
+		{ClassName: "BtnPrimary", Expected: true},
+		{ClassName: "-webkit", Expected: true},
+		{ClassName: "--modifier", Expected: true},
+		{ClassName: "_private", Expected: true},
+		{ClassName: "2col", Expected: true},
+		{ClassName: "col-12", Expected: true},
+		{ClassName: "_", Expected: true},
+		{ClassName: "123", Expected: false},
+		{ClassName: "-", Expected: false},
+		{ClassName: "--", Expected: false},
+		{ClassName: "1-2", Expected: false},
+		{ClassName: "", Expected: false},
+		{ClassName: " btn", Expected: false},
+		{ClassName: "md:flex", Expected: false},
+		{ClassName: "w-1/2", Expected: false},
+		{ClassName: "w-1.5", Expected: false},
+		{ClassName: "[mask-type:luminance]", Expected: false},
+		{ClassName: "!important", Expected: false},
+		{ClassName: "café", Expected: false},
+		{ClassName: "日本", Expected: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.ClassName, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, util.IsValidJadeClassName(tc.ClassName))
+		})
+	}
+}
+
+func TestIsValidJadeTagName(t *testing.T) {
+
+	type TestCase struct {
+		TagName  string
+		Expected bool
+	}
+
+	testCases := []TestCase{
+		{TagName: "p", Expected: true},
+		{TagName: "h1", Expected: true},
+		{TagName: "my-widget", Expected: true},
+		{TagName: "fb:like", Expected: true},
+		{TagName: "linearGradient", Expected: true},
+		{TagName: "x_y", Expected: true},
+		{TagName: "", Expected: false},
+		{TagName: "my-widget-", Expected: false},
+		{TagName: "fb:", Expected: false},
+		{TagName: "-x", Expected: false},
+		{TagName: "x.y", Expected: false},
+		{TagName: "x$y", Expected: false},
+		{TagName: "café", Expected: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.TagName, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, util.IsValidJadeTagName(tc.TagName))
+		})
+	}
+}
+
+func TestAttributeNames(t *testing.T) {
+
+	type TestCase struct {
+		AttrName      string
+		Plain         bool
+		Representable bool
+	}
+
+	testCases := []TestCase{
+		{AttrName: "href", Plain: true, Representable: true},
+		{AttrName: "data-x", Plain: true, Representable: true},
+		{AttrName: "xlink:href", Plain: true, Representable: true},
+		{AttrName: "@click", Plain: true, Representable: true},
+		{AttrName: "v-on:click.prevent", Plain: true, Representable: true},
+		{AttrName: "(click)", Plain: false, Representable: true},
+		{AttrName: "[disabled]", Plain: false, Representable: true},
+		{AttrName: "[(ngModel)]", Plain: false, Representable: true},
+		{AttrName: "a'b", Plain: false, Representable: true},
+		{AttrName: "a,b", Plain: false, Representable: true},
+		{AttrName: "a'b\"c", Plain: false, Representable: false},
+		{AttrName: "a\tb", Plain: false, Representable: false},
+		{AttrName: "", Plain: false, Representable: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.AttrName, func(t *testing.T) {
+			assert.Equal(t, tc.Plain, util.IsPlainAttributeName(tc.AttrName))
+			assert.Equal(t, tc.Representable, util.IsRepresentableAttributeName(tc.AttrName))
+		})
+	}
+}
+
+const selectorHTML = `<div id="main" class="page wide">
+<a id="home" href="/" lang="en-GB">Home</a>
+<a id="ext" href="https://analytics.example.com/t.js" rel="nofollow noopener" data-x="A">Ext</a>
+<p id="lead" class="lead js-x">Lead</p>
+<svg id="icon" viewBox="0 0 1 1"></svg>
+</div>`
+
+func TestCompileSelector(t *testing.T) {
+
+	doc, err := html.Parse(strings.NewReader(selectorHTML))
+	assert.NoError(t, err)
+
+	type TestCase struct {
+		Selector    string
+		ExpectedIds []string
+		Error       bool
+	}
+
+	testCases := []TestCase{
+		{Selector: "a", ExpectedIds: []string{"home", "ext"}},
+		{Selector: "A", ExpectedIds: []string{"home", "ext"}},
+		{Selector: "#lead", ExpectedIds: []string{"lead"}},
+		{Selector: "div.page.wide", ExpectedIds: []string{"main"}},
+		{Selector: ".page.narrow", ExpectedIds: nil},
+		{Selector: "[href]", ExpectedIds: []string{"home", "ext"}},
+		{Selector: `[href="/"]`, ExpectedIds: []string{"home"}},
+		{Selector: "[href*=analytics]", ExpectedIds: []string{"ext"}},
+		{Selector: "[href^='https:']", ExpectedIds: []string{"ext"}},
+		{Selector: `[href$=".js"]`, ExpectedIds: []string{"ext"}},
+		{Selector: "[rel~=noopener]", ExpectedIds: []string{"ext"}},
+		{Selector: "[lang|=en]", ExpectedIds: []string{"home"}},
+		{Selector: "[data-x=a i]", ExpectedIds: []string{"ext"}},
+		{Selector: "[data-x=a]", ExpectedIds: nil},
+		{Selector: "[viewbox]", ExpectedIds: []string{"icon"}},
+		{Selector: "p, svg", ExpectedIds: []string{"lead", "icon"}},
+		{Selector: "*#home", ExpectedIds: []string{"home"}},
+		{Selector: "a[", Error: true},
+		{Selector: "a,", Error: true},
+		{Selector: "[href=]", Error: true},
+		{Selector: "", Error: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Selector, func(t *testing.T) {
+			selector, err := util.CompileSelector(tc.Selector)
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			var ids []string
+			var walk func(node *html.Node)
+			walk = func(node *html.Node) {
+				if selector.Match(node) {
+					ids = append(ids, util.GetAttr(node, "id"))
+				}
+				for child := node.FirstChild; child != nil; child = child.NextSibling {
+					walk(child)
+				}
+			}
+			walk(doc)
+			assert.Equal(t, tc.ExpectedIds, ids)
+		})
+	}
+}
+
+func TestCloneNode(t *testing.T) {
+
+	root, err := html.Parse(strings.NewReader(`<div id="a"><p class="b">one<b>two</b></p>three</div>`))
+	assert.NoError(t, err)
+	render := func(node *html.Node) string {
+		var builder strings.Builder
+		assert.NoError(t, html.Render(&builder, node))
+		return builder.String()
+	}
+	original := render(root)
+
+	clones := map[*html.Node]*html.Node{}
+	clone := util.CloneNode(root, clones)
+	assert.Equal(t, original, render(clone))
+	assert.Len(t, clones, 10)
+
+	// Changing the copy leaves the original untouched
+	for node, copy := range clones {
+		assert.NotSame(t, node, copy)
+		if copy.Data == "p" {
+			util.SetAttr(copy, "class", "c")
+			util.UnwrapNode(copy)
+		}
+	}
+	assert.NotEqual(t, original, render(clone))
+	assert.Equal(t, original, render(root))
+}
+
+func TestMergeTextNodes(t *testing.T) {
+
+	div := &html.Node{Type: html.ElementNode, Data: "div"}
+	br := &html.Node{Type: html.ElementNode, Data: "br"}
+	one := &html.Node{Type: html.TextNode, Data: "one "}
+	two := &html.Node{Type: html.TextNode, Data: "two"}
+	three := &html.Node{Type: html.TextNode, Data: "three"}
+	div.AppendChild(one)
+	div.AppendChild(two)
+	div.AppendChild(br)
+	div.AppendChild(three)
+
+	merged, last := util.MergeTextNodes(one)
+	assert.Equal(t, "one two", merged.Data)
+	assert.Same(t, two, last)
+	assert.Same(t, div, merged.Parent)
+	assert.Same(t, br, merged.NextSibling)
+	assert.Nil(t, merged.PrevSibling)
+	// The run is left in the tree as it was
+	assert.Equal(t, "one ", one.Data)
+	assert.Same(t, two, one.NextSibling)
+
+	merged, last = util.MergeTextNodes(three)
+	assert.Same(t, three, merged)
+	assert.Same(t, three, last)
+}
+
+func TestCompileGlob(t *testing.T) {
+
+	type TestCase struct {
+		Pattern  string
+		Name     string
+		Expected bool
+		Error    bool
+	}
+
+	testCases := []TestCase{
+		{Pattern: "js-*", Name: "js-init", Expected: true},
+		{Pattern: "js-*", Name: "x-js-init", Expected: false},
+		{Pattern: "w-*", Name: "w-1/2", Expected: true},
+		{Pattern: "*/2", Name: "w-1/2", Expected: true},
+		{Pattern: "data-?", Name: "data-x", Expected: true},
+		{Pattern: "data-?", Name: "data-xy", Expected: false},
+		{Pattern: "h-[0-9]", Name: "h-4", Expected: true},
+		{Pattern: "h-[!0-9]", Name: "h-4", Expected: false},
+		{Pattern: "h-[^0-9]", Name: "h-x", Expected: true},
+		{Pattern: "md:p-*", Name: "md:p-4", Expected: true},
+		{Pattern: `a\*`, Name: "a*", Expected: true},
+		{Pattern: `a\*`, Name: "ab", Expected: false},
+		{Pattern: "a.b", Name: "axb", Expected: false},
+		{Pattern: "js-[", Error: true},
+		{Pattern: `a\`, Error: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Pattern+" "+tc.Name, func(t *testing.T) {
+			glob, err := util.CompileGlob(tc.Pattern)
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.Expected, glob.Match(tc.Name))
+			}
+		})
+	}
+}